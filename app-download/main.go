@@ -3,14 +3,33 @@ package main
 import (
 	"log"
 	"net/http"
+	"os"
+	"time"
 
 	toolkit "github.com/cmichels/buidling-a-module-go"
 )
 
 
+var tools = toolkit.Tools{
+  DownloadRate: 512 << 10,
+  DownloadBurst: 1 << 20,
+  DownloadSlots: toolkit.NewDownloadSlots(10),
+  OnAudit: toolkit.AuditJSONLines(os.Stdout),
+}
+
+
 
 func main() {
-  
+
+  // the signing secret must come from the environment, never from source
+  secret := os.Getenv("DOWNLOAD_SIGNING_SECRET")
+
+  if secret == "" {
+    log.Fatal("DOWNLOAD_SIGNING_SECRET must be set")
+  }
+
+  tools.SigningKeys = []toolkit.SigningKey{{ID: "1", Secret: []byte(secret)}}
+
   mux := routes()
 
   err := http.ListenAndServe(":8080", mux)
@@ -27,14 +46,26 @@ func routes() http.Handler {
 
   mux.Handle("/", http.StripPrefix("/", http.FileServer(http.Dir("."))))
   mux.HandleFunc("/download", downloadFile)
+  mux.HandleFunc("/link", signedLink)
+  mux.Handle("/private", tools.RequireSignedURL(http.HandlerFunc(downloadFile)))
 
   return mux
 }
 
 
 func downloadFile(w http.ResponseWriter, r *http.Request)  {
-  
-  var tools toolkit.Tools
 
   tools.DownloadStaticFile(w, r, "./files", "pic.jpg", "puppy.jpg")
 }
+
+
+func signedLink(w http.ResponseWriter, r *http.Request)  {
+
+  link, err := tools.SignURL("/private", 5*time.Minute)
+  if err != nil {
+    http.Error(w, err.Error(), http.StatusInternalServerError)
+    return
+  }
+
+  _, _ = w.Write([]byte(link))
+}
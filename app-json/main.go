@@ -9,8 +9,8 @@ import (
 )
 
 type RequestPayload struct {
-	Action  string `json:"action"`
-	Message string `json:"message"`
+	Action  string `json:"action" validate:"required"`
+	Message string `json:"message" validate:"max=500"`
 }
 
 type ResponsePayload struct {
@@ -38,10 +38,9 @@ func main() {
 }
 
 func receivePost(w http.ResponseWriter, r *http.Request) {
-	var requestPayload RequestPayload
-	var t toolkit.Tools
+	t := toolkit.Tools{ValidateJSON: true, ProblemJSON: true}
 
-	err := t.ReadJSON(w, r, &requestPayload)
+	_, err := toolkit.ReadJSONAs[RequestPayload](w, r, &t)
 
 	if err != nil {
 		t.ErrorJSON(w, err)
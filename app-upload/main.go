@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -9,6 +10,9 @@ import (
 )
 
 
+var tracker toolkit.ProgressTracker
+
+
 func main() {
   mux := routes()
   
@@ -30,6 +34,7 @@ func routes() http.Handler {
   mux.Handle("/", http.StripPrefix("/", http.FileServer(http.Dir("."))))
   mux.HandleFunc("/upload", uploadlfiles)
   mux.HandleFunc("/upload-one", uploadOneFile)
+  mux.Handle("/progress", &tracker)
 
   return mux
 }
@@ -46,12 +51,13 @@ func uploadlfiles(w http.ResponseWriter, r *http.Request)  {
   t := toolkit.Tools{
     MaxFileSize: 1024 * 1024 * 1024,
     AllowedFileTypes: []string{"image/jpeg", "image/png", "image/gif"},
+    OnProgress: tracker.Update,
   }
 
 
   files, err := t.UploadFiles(r, "./uploads")
   if err != nil{
-    http.Error(w, err.Error(), http.StatusBadRequest)
+    http.Error(w, err.Error(), uploadStatus(err))
     return
   }
 
@@ -82,7 +88,7 @@ func uploadOneFile(w http.ResponseWriter, r *http.Request)  {
 
   file, err := t.UploadOneFile(r, "./uploads")
   if err != nil{
-    http.Error(w, err.Error(), http.StatusBadRequest)
+    http.Error(w, err.Error(), uploadStatus(err))
     return
   }
 
@@ -92,3 +98,17 @@ func uploadOneFile(w http.ResponseWriter, r *http.Request)  {
 
 
 }
+
+
+func uploadStatus(err error) int {
+  switch {
+  case errors.Is(err, toolkit.ErrFileTooLarge),
+    errors.Is(err, toolkit.ErrRequestTooLarge),
+    errors.Is(err, toolkit.ErrTooManyFiles):
+    return http.StatusRequestEntityTooLarge
+  case errors.Is(err, toolkit.ErrInfectedFile):
+    return http.StatusUnprocessableEntity
+  default:
+    return http.StatusBadRequest
+  }
+}
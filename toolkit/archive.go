@@ -0,0 +1,229 @@
+package toolkit
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// ArchiveFormat selects the kind of archive DownloadArchive produces
+type ArchiveFormat int
+
+const (
+	// ArchiveZip produces a deflate compressed zip file
+	ArchiveZip ArchiveFormat = iota
+	// ArchiveTarGz produces a gzip compressed tar file
+	ArchiveTarGz
+)
+
+// ArchiveEntry is a stored file to include in an archive. ArchiveName is
+// the slash separated path it gets inside the archive and defaults to
+// the base name of Name.
+type ArchiveEntry struct {
+	Name        string
+	ArchiveName string
+}
+
+// ArchiveEntries lists every file below dir in the configured Storage,
+// named by their path relative to dir. Hidden files are left out.
+func (t *Tools) ArchiveEntries(dir string) ([]ArchiveEntry, error) {
+	prefix := cleanPrefix(dir)
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	files, err := t.storage().List(prefix)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []ArchiveEntry
+
+	for _, f := range files {
+		name := strings.TrimPrefix(f.Name, prefix)
+
+		if hiddenPath(name) {
+			continue
+		}
+
+		entries = append(entries, ArchiveEntry{Name: f.Name, ArchiveName: name})
+	}
+
+	return entries, nil
+}
+
+// DownloadArchive streams entries from the configured Storage to w as a
+// single zip or tar.gz archive named displayName, without building it in
+// memory or in a temporary file.
+//
+// Every entry is checked before anything is sent: missing files give a
+// 404 and archives holding more than MaxArchiveSize bytes, before
+// compression, a 413. Should reading a file fail once streaming has
+// begun the archive is left unterminated, so the client sees a broken
+// download rather than a silently incomplete one.
+//
+// Archives count towards DownloadSlots and are throttled to
+// DownloadRate like any other download. The AuditEvent sent to OnAudit
+// lists the stored names of the entries.
+func (t *Tools) DownloadArchive(w http.ResponseWriter, r *http.Request, format ArchiveFormat, displayName string, entries []ArchiveEntry) {
+	w, audit := t.auditDownload(w, r, "", displayName)
+	defer audit.finish()
+
+	if audit != nil {
+		for _, entry := range entries {
+			audit.event.Entries = append(audit.event.Entries, entry.Name)
+		}
+	}
+
+	w, done, ok := t.startDownload(w, r)
+
+	if !ok {
+		return
+	}
+
+	defer done()
+
+	store := t.storage()
+
+	entries = append([]ArchiveEntry(nil), entries...)
+	infos := make([]*StoredFile, len(entries))
+	seen := make(map[string]bool)
+	var total int64
+
+	for i, entry := range entries {
+		if entry.ArchiveName == "" {
+			entries[i].ArchiveName = path.Base(entry.Name)
+		}
+
+		name := entries[i].ArchiveName
+
+		if !fs.ValidPath(name) || name == "." || seen[name] {
+			http.Error(w, fmt.Sprintf("invalid archive entry name %q", name), http.StatusInternalServerError)
+			return
+		}
+
+		seen[name] = true
+
+		info, err := store.Stat(entry.Name)
+
+		if err != nil {
+			storageError(w, r, err)
+			return
+		}
+
+		infos[i] = info
+		total += info.Size
+	}
+
+	if t.MaxArchiveSize > 0 && total > t.MaxArchiveSize {
+		http.Error(w, fmt.Sprintf("archive is larger than %d bytes", t.MaxArchiveSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	disposition, err := ContentDisposition(DispositionAttachment, displayName)
+
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", disposition)
+
+	if format == ArchiveTarGz {
+		w.Header().Set("Content-Type", "application/gzip")
+		err = writeTarGz(w, store, entries, infos)
+	} else {
+		w.Header().Set("Content-Type", "application/zip")
+		err = writeZip(w, store, entries, infos)
+	}
+
+	if err != nil {
+		audit.fail(err)
+	}
+}
+
+func writeZip(w io.Writer, store Storage, entries []ArchiveEntry, infos []*StoredFile) error {
+	zw := zip.NewWriter(w)
+
+	for i, entry := range entries {
+		dst, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     entry.ArchiveName,
+			Method:   zip.Deflate,
+			Modified: infos[i].ModTime,
+		})
+
+		if err != nil {
+			return err
+		}
+
+		if err = copyEntry(dst, store, entry.Name, infos[i].Size); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func writeTarGz(w io.Writer, store Storage, entries []ArchiveEntry, infos []*StoredFile) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	for i, entry := range entries {
+		err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     entry.ArchiveName,
+			Size:     infos[i].Size,
+			Mode:     0644,
+			ModTime:  infos[i].ModTime,
+			Format:   tar.FormatPAX,
+		})
+
+		if err != nil {
+			return err
+		}
+
+		if err = copyEntry(tw, store, entry.Name, infos[i].Size); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	return gw.Close()
+}
+
+// copyEntry copies exactly size bytes of the stored file name to dst,
+// failing if the file changed size since it was checked
+func copyEntry(dst io.Writer, store Storage, name string, size int64) error {
+	f, err := store.Get(name)
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	_, err = io.CopyN(dst, f, size)
+
+	return err
+}
+
+// hiddenPath reports whether any segment of a slash separated name starts with a dot
+func hiddenPath(name string) bool {
+	for _, segment := range strings.Split(name, "/") {
+		if strings.HasPrefix(segment, ".") {
+			return true
+		}
+	}
+
+	return false
+}
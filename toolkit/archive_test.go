@@ -0,0 +1,193 @@
+package toolkit
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func newArchiveStorage(t *testing.T) *MemoryStorage {
+	store := &MemoryStorage{}
+
+	files := map[string]string{
+		"albums/summer/beach.jpg":  "beach",
+		"albums/summer/sunset.jpg": "sunset",
+		"albums/summer/.DS_Store":  "junk",
+		"albums/winter/snow.jpg":   "snow",
+	}
+
+	for name, content := range files {
+		if _, err := store.Put(name, strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return store
+}
+
+// readArchive returns the entries of a zip or tar.gz archive by name
+func readArchive(t *testing.T, format ArchiveFormat, data []byte) map[string]string {
+	contents := make(map[string]string)
+
+	if format == ArchiveZip {
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, f := range zr.File {
+			rc, _ := f.Open()
+			content, _ := io.ReadAll(rc)
+			rc.Close()
+			contents[f.Name] = string(content)
+		}
+
+		return contents
+	}
+
+	gr, err := gzip.NewReader(bytes.NewReader(data))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr := tar.NewReader(gr)
+
+	for {
+		header, err := tr.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		content, _ := io.ReadAll(tr)
+		contents[header.Name] = string(content)
+	}
+
+	return contents
+}
+
+func TestTools_DownloadArchive(t *testing.T) {
+	testTools := Tools{Storage: newArchiveStorage(t)}
+
+	entries := []ArchiveEntry{
+		{Name: "albums/summer/beach.jpg"},
+		{Name: "albums/winter/snow.jpg", ArchiveName: "winter/first-snow.jpg"},
+	}
+
+	for _, format := range []ArchiveFormat{ArchiveZip, ArchiveTarGz} {
+		rr := httptest.NewRecorder()
+
+		testTools.DownloadArchive(rr, httptest.NewRequest("GET", "/", nil), format, "photos", entries)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("format %d: unexpected status %d", format, rr.Code)
+		}
+
+		if rr.Header().Get("Content-Disposition") != `attachment; filename="photos"` {
+			t.Errorf("format %d: unexpected content disposition %s", format, rr.Header().Get("Content-Disposition"))
+		}
+
+		contents := readArchive(t, format, rr.Body.Bytes())
+
+		if len(contents) != 2 || contents["beach.jpg"] != "beach" || contents["winter/first-snow.jpg"] != "snow" {
+			t.Errorf("format %d: unexpected archive contents %v", format, contents)
+		}
+	}
+
+	if entries[0].ArchiveName != "" {
+		t.Error("DownloadArchive modified the caller's entries")
+	}
+}
+
+func TestTools_ArchiveEntries(t *testing.T) {
+	testTools := Tools{Storage: newArchiveStorage(t)}
+
+	entries, err := testTools.ArchiveEntries("albums")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.ArchiveName)
+	}
+
+	sort.Strings(names)
+
+	if strings.Join(names, ",") != "summer/beach.jpg,summer/sunset.jpg,winter/snow.jpg" {
+		t.Errorf("unexpected entries %v", names)
+	}
+}
+
+var archiveErrorTests = []struct {
+	name    string
+	entries []ArchiveEntry
+	maxSize int64
+	status  int
+}{
+	{name: "missing file", entries: []ArchiveEntry{{Name: "albums/missing.jpg"}}, status: http.StatusNotFound},
+	{name: "over size cap", entries: []ArchiveEntry{{Name: "albums/summer/beach.jpg"}, {Name: "albums/summer/sunset.jpg"}}, maxSize: 8, status: http.StatusRequestEntityTooLarge},
+	{name: "escaping entry name", entries: []ArchiveEntry{{Name: "albums/summer/beach.jpg", ArchiveName: "../../etc/cron.d/x"}}, status: http.StatusInternalServerError},
+	{name: "duplicate entry name", entries: []ArchiveEntry{{Name: "albums/summer/beach.jpg", ArchiveName: "a.jpg"}, {Name: "albums/winter/snow.jpg", ArchiveName: "a.jpg"}}, status: http.StatusInternalServerError},
+}
+
+func TestTools_DownloadArchiveErrors(t *testing.T) {
+	for _, e := range archiveErrorTests {
+		testTools := Tools{Storage: newArchiveStorage(t), MaxArchiveSize: e.maxSize}
+
+		rr := httptest.NewRecorder()
+
+		testTools.DownloadArchive(rr, httptest.NewRequest("GET", "/", nil), ArchiveZip, "photos.zip", e.entries)
+
+		if rr.Code != e.status {
+			t.Errorf("%s: expected status %d, got %d", e.name, e.status, rr.Code)
+		}
+
+		if rr.Header().Get("Content-Disposition") != "" {
+			t.Errorf("%s: archive headers sent for a failed download", e.name)
+		}
+	}
+}
+
+func TestTools_ArchiveEntriesSkipsSymlinks(t *testing.T) {
+	root := t.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(root, "uploads"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = os.WriteFile(filepath.Join(root, "uploads", "a.txt"), []byte("public"), 0644)
+	_ = os.WriteFile(filepath.Join(root, "secret.txt"), []byte("secret"), 0644)
+
+	if err := os.Symlink(filepath.Join(root, "secret.txt"), filepath.Join(root, "uploads", "link.txt")); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+
+	testTools := Tools{Storage: LocalStorage{Root: root}}
+
+	entries, err := testTools.ArchiveEntries("uploads")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 || entries[0].Name != "uploads/a.txt" {
+		t.Errorf("expected only uploads/a.txt, got %v", entries)
+	}
+}
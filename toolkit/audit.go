@@ -0,0 +1,224 @@
+package toolkit
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AuditKind tells downloads and uploads apart in an AuditEvent
+type AuditKind int
+
+const (
+	// AuditDownload is a file, reader or archive sent to a client
+	AuditDownload AuditKind = iota
+	// AuditUpload is a file received from a client
+	AuditUpload
+)
+
+func (k AuditKind) String() string {
+	if k == AuditUpload {
+		return "upload"
+	}
+
+	return "download"
+}
+
+func (k AuditKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// AuditEvent is passed to Tools.OnAudit once a download or an uploaded
+// file is finished with, whether it succeeded or not.
+//
+// For downloads File is the requested storage name, DisplayName the name
+// offered to the browser and Bytes what was written to the client after
+// compression. Completed is false when the client went away, the body
+// was cut short or the status was an error.
+//
+// For uploads File is the stored name, DisplayName the name the client
+// sent and Bytes what was read of the file. Status is 200 for stored
+// files and the status errorStatus gives the error otherwise; files
+// deleted again because of AllOrNothing are reported as not completed.
+type AuditEvent struct {
+	Kind        AuditKind     `json:"kind"`
+	Time        time.Time     `json:"time"`
+	File        string        `json:"file,omitempty"`
+	DisplayName string        `json:"display_name,omitempty"`
+	Entries     []string      `json:"entries,omitempty"`
+	Bytes       int64         `json:"bytes"`
+	Range       string        `json:"range,omitempty"`
+	Status      int           `json:"status"`
+	Duration    time.Duration `json:"duration_ns"`
+	ClientIP    string        `json:"client_ip"`
+	Completed   bool          `json:"completed"`
+	Error       string        `json:"error,omitempty"`
+}
+
+// AuditJSONLines returns an OnAudit hook writing each event to w as a
+// single line of JSON. It is safe for concurrent use; write errors are
+// ignored so a failing log never breaks a transfer.
+func AuditJSONLines(w io.Writer) func(AuditEvent) {
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+
+	return func(event AuditEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		_ = enc.Encode(event)
+	}
+}
+
+// auditWriter records the status and size of a download for OnAudit.
+// A nil *auditWriter records nothing.
+type auditWriter struct {
+	http.ResponseWriter
+	event         AuditEvent
+	report        func(AuditEvent)
+	ctx           context.Context
+	head          bool
+	contentLength int64
+	wroteHeader   bool
+}
+
+// auditDownload starts auditing a download of file, returning the writer
+// to send it through. finish must be called once the download is over.
+func (t *Tools) auditDownload(w http.ResponseWriter, r *http.Request, file, displayName string) (http.ResponseWriter, *auditWriter) {
+	if t.OnAudit == nil {
+		return w, nil
+	}
+
+	a := &auditWriter{
+		ResponseWriter: w,
+		event: AuditEvent{
+			Kind:        AuditDownload,
+			Time:        time.Now(),
+			File:        file,
+			DisplayName: displayName,
+			Range:       r.Header.Get("Range"),
+			ClientIP:    clientIP(r),
+		},
+		report:        t.OnAudit,
+		ctx:           r.Context(),
+		head:          r.Method == http.MethodHead,
+		contentLength: -1,
+	}
+
+	return a, a
+}
+
+func (a *auditWriter) WriteHeader(status int) {
+	if !a.wroteHeader && status >= http.StatusOK {
+		a.wroteHeader = true
+		a.event.Status = status
+
+		if cl, err := strconv.ParseInt(a.Header().Get("Content-Length"), 10, 64); err == nil {
+			a.contentLength = cl
+		}
+	}
+
+	a.ResponseWriter.WriteHeader(status)
+}
+
+func (a *auditWriter) Write(p []byte) (int, error) {
+	if !a.wroteHeader {
+		a.WriteHeader(http.StatusOK)
+	}
+
+	n, err := a.ResponseWriter.Write(p)
+	a.event.Bytes += int64(n)
+
+	if err != nil {
+		a.fail(err)
+	}
+
+	return n, err
+}
+
+func (a *auditWriter) Flush() {
+	if flusher, ok := a.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (a *auditWriter) Unwrap() http.ResponseWriter {
+	return a.ResponseWriter
+}
+
+// fail marks the download as aborted by err
+func (a *auditWriter) fail(err error) {
+	if a == nil || a.event.Error != "" {
+		return
+	}
+
+	a.event.Error = err.Error()
+}
+
+// finish reports the download to OnAudit
+func (a *auditWriter) finish() {
+	if a == nil {
+		return
+	}
+
+	if a.event.Status == 0 {
+		a.event.Status = http.StatusOK
+	}
+
+	if err := a.ctx.Err(); err != nil {
+		a.fail(err)
+	}
+
+	short := !a.head && a.event.Status != http.StatusNotModified &&
+		a.contentLength >= 0 && a.event.Bytes < a.contentLength
+
+	a.event.Duration = time.Since(a.event.Time)
+	a.event.Completed = a.event.Error == "" && !short && a.event.Status < http.StatusBadRequest
+
+	a.report(a.event)
+}
+
+// uploadEvent describes an uploaded file, or the attempt at one, for OnAudit
+func uploadEvent(r *http.Request, uploadDir, fileName string, uploadedFile *UploadedFile, size int64, err error, start time.Time) AuditEvent {
+	event := AuditEvent{
+		Kind:        AuditUpload,
+		Time:        start,
+		DisplayName: fileName,
+		Bytes:       size,
+		Status:      http.StatusOK,
+		Duration:    time.Since(start),
+		ClientIP:    clientIP(r),
+		Completed:   err == nil,
+	}
+
+	if uploadedFile != nil {
+		event.File = path.Join(filepath.ToSlash(uploadDir), uploadedFile.NewFileName)
+		event.DisplayName = uploadedFile.OriginalFileName
+	}
+
+	if err != nil {
+		event.Status = errorStatus(err)
+		event.Error = err.Error()
+	}
+
+	return event
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+
+	return n, err
+}
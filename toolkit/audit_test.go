@@ -0,0 +1,175 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var auditDownloadTests = []struct {
+	name      string
+	headers   map[string]string
+	status    int
+	bytes     int64
+	completed bool
+}{
+	{name: "full download", status: http.StatusOK, bytes: int64(len(downloadContent)), completed: true},
+	{name: "range", headers: map[string]string{"Range": "bytes=0-3"}, status: http.StatusPartialContent, bytes: 4, completed: true},
+	{name: "not modified", headers: map[string]string{"If-Modified-Since": "Sat, 01 Jan 2050 00:00:00 GMT"}, status: http.StatusNotModified, completed: true},
+	{name: "unsatisfiable range", headers: map[string]string{"Range": "bytes=50-60"}, status: http.StatusRequestedRangeNotSatisfiable},
+}
+
+func TestTools_AuditDownload(t *testing.T) {
+	modTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, e := range auditDownloadTests {
+		var events []AuditEvent
+		testTools := Tools{OnAudit: func(event AuditEvent) { events = append(events, event) }}
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "192.0.2.7:4321"
+
+		for k, v := range e.headers {
+			req.Header.Set(k, v)
+		}
+
+		rr := httptest.NewRecorder()
+
+		testTools.DownloadReader(rr, req, "notes.txt", modTime, strings.NewReader(downloadContent))
+
+		if len(events) != 1 {
+			t.Errorf("%s: expected one event, got %d", e.name, len(events))
+			continue
+		}
+
+		event := events[0]
+
+		if event.Kind != AuditDownload || event.File != "notes.txt" || event.DisplayName != "notes.txt" || event.ClientIP != "192.0.2.7" {
+			t.Errorf("%s: unexpected event %+v", e.name, event)
+		}
+
+		if event.Status != e.status || event.Status != rr.Code {
+			t.Errorf("%s: expected status %d, got %d", e.name, e.status, event.Status)
+		}
+
+		if e.bytes > 0 && event.Bytes != e.bytes {
+			t.Errorf("%s: expected %d bytes, got %d", e.name, e.bytes, event.Bytes)
+		}
+
+		if event.Range != e.headers["Range"] {
+			t.Errorf("%s: expected range %q, got %q", e.name, e.headers["Range"], event.Range)
+		}
+
+		if event.Completed != e.completed {
+			t.Errorf("%s: expected completed %t, got %t", e.name, e.completed, event.Completed)
+		}
+	}
+}
+
+func TestTools_AuditDownloadAborted(t *testing.T) {
+	var events []AuditEvent
+	testTools := Tools{DownloadRate: 100, OnAudit: func(event AuditEvent) { events = append(events, event) }}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	req := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+
+	testTools.DownloadReader(httptest.NewRecorder(), req, "data.bin", time.Now(), strings.NewReader(strings.Repeat("x", 1000)))
+
+	if len(events) != 1 || events[0].Completed || events[0].Error == "" || events[0].Bytes >= 1000 {
+		t.Errorf("expected one aborted event, got %+v", events)
+	}
+}
+
+func TestTools_AuditStaticFile(t *testing.T) {
+	var events []AuditEvent
+	store := &MemoryStorage{}
+	_, _ = store.Put("files/report.txt", strings.NewReader(downloadContent))
+
+	testTools := Tools{Storage: store, OnAudit: func(event AuditEvent) { events = append(events, event) }}
+
+	for _, file := range []string{"report.txt", "missing.txt"} {
+		testTools.DownloadStaticFile(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), "files", file, "report.txt")
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("expected two events, got %d", len(events))
+	}
+
+	if events[0].File != "files/report.txt" || !events[0].Completed || events[0].Bytes != int64(len(downloadContent)) {
+		t.Errorf("unexpected event for stored file %+v", events[0])
+	}
+
+	if events[1].File != "files/missing.txt" || events[1].Completed || events[1].Status != http.StatusNotFound {
+		t.Errorf("unexpected event for missing file %+v", events[1])
+	}
+}
+
+func TestTools_AuditUpload(t *testing.T) {
+	body, contentType := newUploadBody(t, "one.png", "two.png")
+
+	for _, allOrNothing := range []bool{false, true} {
+		var events []AuditEvent
+
+		request := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+		request.Header.Add("Content-Type", contentType)
+
+		testTools := Tools{
+			MaxFileCount: 1,
+			AllOrNothing: allOrNothing,
+			Storage:      &MemoryStorage{},
+			OnAudit:      func(event AuditEvent) { events = append(events, event) },
+		}
+
+		_, _ = testTools.UploadFiles(request, "uploads", false)
+
+		if len(events) != 2 {
+			t.Errorf("expected an event for the file and one for the error, got %+v", events)
+			continue
+		}
+
+		stored := events[0]
+
+		if stored.Kind != AuditUpload || stored.File != "uploads/one.png" || stored.DisplayName != "one.png" || stored.Bytes == 0 {
+			t.Errorf("unexpected event for stored file %+v", stored)
+		}
+
+		if stored.Completed == allOrNothing {
+			t.Errorf("all or nothing %t: expected completed %t, got %t", allOrNothing, !allOrNothing, stored.Completed)
+		}
+
+		if events[1].Completed || events[1].Status != http.StatusRequestEntityTooLarge {
+			t.Errorf("unexpected event for rejected request %+v", events[1])
+		}
+	}
+}
+
+func TestAuditJSONLines(t *testing.T) {
+	var buf bytes.Buffer
+	sink := AuditJSONLines(&buf)
+
+	sink(AuditEvent{Kind: AuditUpload, File: "uploads/a.png", Status: http.StatusOK, Completed: true})
+	sink(AuditEvent{Kind: AuditDownload, File: "files/b.txt", Status: http.StatusNotFound})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+
+	if len(lines) != 2 {
+		t.Fatalf("expected two lines, got %q", buf.String())
+	}
+
+	var decoded map[string]any
+
+	if err := json.Unmarshal([]byte(lines[0]), &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded["kind"] != "upload" || decoded["file"] != "uploads/a.png" || decoded["completed"] != true {
+		t.Errorf("unexpected line %s", lines[0])
+	}
+}
@@ -0,0 +1,315 @@
+package toolkit
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	uploadedFileType  = reflect.TypeOf((*UploadedFile)(nil))
+	uploadedFilesType = reflect.TypeOf([]*UploadedFile(nil))
+	textUnmarshaler   = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// formBinding maps form field names onto the struct fields of a ReadMultipart destination
+type formBinding struct {
+	values map[string]reflect.Value
+	files  map[string]reflect.Value
+	rules  map[string]FieldRule
+}
+
+// ReadMultipart reads a multipart form into the struct pointed to by dst.
+//
+// Text fields are bound to struct fields tagged `form:"name"`, which may
+// be strings, bools, numbers, types implementing encoding.TextUnmarshaler
+// or slices of these. Files are uploaded to uploadDir of the configured
+// Storage as with UploadForm, and bound to *UploadedFile or
+// []*UploadedFile fields tagged `file:"name"`; uploadDir may only be
+// empty when dst has no such fields. A file tag may end in ",required",
+// and be combined with `maxsize:"5MB"` and `types:"image/png,image/jpeg"`.
+// These tighten the FieldRules entry of that field, if any, rather than
+// replacing it.
+//
+// Fields that dst has no place for are an error unless AllowUnknownFields
+// is set. When binding fails, the uploaded files are deleted again.
+func (t *Tools) ReadMultipart(w http.ResponseWriter, r *http.Request, dst any, uploadDir string) error {
+	v := reflect.ValueOf(dst)
+
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return errors.New("error binding form: destination must be a pointer to a struct")
+	}
+
+	binding, err := newFormBinding(v.Elem(), t.FieldRules)
+
+	if err != nil {
+		return err
+	}
+
+	if uploadDir == "" && len(binding.files) > 0 {
+		return errors.New("error binding form: an upload directory is required for file fields")
+	}
+
+	if t.MaxRequestSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, t.MaxRequestSize)
+	}
+
+	formTools := *t
+	formTools.AllOrNothing = true
+	formTools.FieldRules = make(map[string]FieldRule)
+
+	for field, rule := range t.FieldRules {
+		formTools.FieldRules[field] = rule
+	}
+
+	for field, rule := range binding.rules {
+		formTools.FieldRules[field] = rule
+	}
+
+	// files in fields dst has no place for are refused or skipped as they
+	// arrive, so none is ever stored without being returned
+	result, err := formTools.uploadForm(r, uploadDir, true, func(field string) (bool, error) {
+		if _, ok := binding.files[field]; ok {
+			return true, nil
+		}
+
+		if t.AllowUnknownFields {
+			return false, nil
+		}
+
+		return false, fmt.Errorf("form contains unknown file field %q", field)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if err = binding.bind(result, t.AllowUnknownFields); err != nil {
+		t.removeUploads(uploadDir, result.Files)
+		return err
+	}
+
+	return nil
+}
+
+// newFormBinding maps the tagged fields of v, merging the rules of file
+// tags over those configured for the same field
+func newFormBinding(v reflect.Value, configured map[string]FieldRule) (*formBinding, error) {
+	binding := &formBinding{
+		values: make(map[string]reflect.Value),
+		files:  make(map[string]reflect.Value),
+		rules:  make(map[string]FieldRule),
+	}
+
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+
+		if !field.IsExported() {
+			continue
+		}
+
+		if name, ok := field.Tag.Lookup("form"); ok {
+			if name != "-" {
+				binding.values[name] = v.Field(i)
+			}
+
+			continue
+		}
+
+		tag, ok := field.Tag.Lookup("file")
+
+		if !ok {
+			continue
+		}
+
+		name, options, _ := strings.Cut(tag, ",")
+
+		rule := configured[name]
+		rule.Required = rule.Required || options == "required"
+
+		switch field.Type {
+		case uploadedFileType:
+			rule.MaxCount = 1
+		case uploadedFilesType:
+		default:
+			return nil, fmt.Errorf("error binding form: field %s must be *UploadedFile or []*UploadedFile", field.Name)
+		}
+
+		if size, ok := field.Tag.Lookup("maxsize"); ok {
+			n, err := parseSize(size)
+
+			if err != nil {
+				return nil, fmt.Errorf("error binding form: field %s has invalid maxsize %q", field.Name, size)
+			}
+
+			rule.MaxFileSize = n
+		}
+
+		if types, ok := field.Tag.Lookup("types"); ok {
+			rule.AllowedFileTypes = nil
+
+			for _, contentType := range strings.Split(types, ",") {
+				rule.AllowedFileTypes = append(rule.AllowedFileTypes, strings.TrimSpace(contentType))
+			}
+		}
+
+		binding.files[name] = v.Field(i)
+		binding.rules[name] = rule
+	}
+
+	return binding, nil
+}
+
+// bind copies the uploaded files and text values into the struct fields
+func (b *formBinding) bind(result *UploadResult, allowUnknown bool) error {
+	for _, name := range sortedKeys(result.Fields) {
+		target, ok := b.files[name]
+
+		if !ok {
+			if allowUnknown {
+				continue
+			}
+
+			return fmt.Errorf("form contains unknown file field %q", name)
+		}
+
+		if target.Type() == uploadedFileType {
+			target.Set(reflect.ValueOf(result.Fields[name][0]))
+		} else {
+			target.Set(reflect.ValueOf(result.Fields[name]))
+		}
+	}
+
+	for _, name := range sortedKeys(result.Values) {
+		target, ok := b.values[name]
+
+		if !ok {
+			if allowUnknown {
+				continue
+			}
+
+			return fmt.Errorf("form contains unknown field %q", name)
+		}
+
+		if err := setFormValue(target, result.Values[name]); err != nil {
+			return fmt.Errorf("form contains incorrect type for field %q", name)
+		}
+	}
+
+	return nil
+}
+
+// setFormValue sets v from the text values of a field; all of them for
+// slices, otherwise the first
+func setFormValue(v reflect.Value, values []string) error {
+	if v.Kind() == reflect.Slice && !v.Addr().Type().Implements(textUnmarshaler) {
+		slice := reflect.MakeSlice(v.Type(), len(values), len(values))
+
+		for i, value := range values {
+			if err := setText(slice.Index(i), value); err != nil {
+				return err
+			}
+		}
+
+		v.Set(slice)
+
+		return nil
+	}
+
+	return setText(v, values[0])
+}
+
+func setText(v reflect.Value, s string) error {
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(s))
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+
+		if err != nil {
+			return err
+		}
+
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+
+		if err != nil {
+			return err
+		}
+
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+
+		if err != nil {
+			return err
+		}
+
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+
+		if err != nil {
+			return err
+		}
+
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+
+	return nil
+}
+
+// parseSize parses sizes such as "512", "100KB", "5MB" or "1GB", in
+// multiples of 1024
+func parseSize(s string) (int, error) {
+	units := []struct {
+		suffix     string
+		multiplier int
+	}{
+		{"GB", 1024 * 1024 * 1024},
+		{"MB", 1024 * 1024},
+		{"KB", 1024},
+		{"B", 1},
+	}
+
+	s = strings.ToUpper(strings.TrimSpace(s))
+	multiplier := 1
+
+	for _, unit := range units {
+		if strings.HasSuffix(s, unit.suffix) {
+			s, multiplier = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix)), unit.multiplier
+			break
+		}
+	}
+
+	n, err := strconv.Atoi(s)
+
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	return n * multiplier, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,186 @@
+package toolkit
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type profileForm struct {
+	Title   string          `form:"title"`
+	Age     int             `form:"age"`
+	Public  bool            `form:"public"`
+	Tags    []string        `form:"tag"`
+	Born    time.Time       `form:"born"`
+	Avatar  *UploadedFile   `file:"avatar,required" maxsize:"1MB" types:"image/png"`
+	Gallery []*UploadedFile `file:"gallery"`
+	ignored string
+}
+
+func TestTools_ReadMultipart(t *testing.T) {
+	body, contentType := newForm(t,
+		formPart{field: "title", value: "me"},
+		formPart{field: "age", value: "42"},
+		formPart{field: "public", value: "true"},
+		formPart{field: "tag", value: "a"},
+		formPart{field: "tag", value: "b"},
+		formPart{field: "born", value: "1982-01-02T00:00:00Z"},
+		formPart{field: "avatar", fileName: "me.png"},
+		formPart{field: "gallery", fileName: "one.png"},
+		formPart{field: "gallery", fileName: "two.png"},
+	)
+
+	request := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+	request.Header.Add("Content-Type", contentType)
+
+	testTools := Tools{Storage: &MemoryStorage{}}
+
+	var form profileForm
+
+	if err := testTools.ReadMultipart(httptest.NewRecorder(), request, &form, "uploads"); err != nil {
+		t.Fatal(err)
+	}
+
+	if form.Title != "me" || form.Age != 42 || !form.Public || len(form.Tags) != 2 || form.Born.Year() != 1982 {
+		t.Errorf("unexpected text fields %+v", form)
+	}
+
+	if form.Avatar == nil || form.Avatar.OriginalFileName != "me.png" || len(form.Gallery) != 2 {
+		t.Errorf("unexpected file fields %+v, %+v", form.Avatar, form.Gallery)
+	}
+}
+
+var readMultipartTests = []struct {
+	name         string
+	parts        []formPart
+	allowUnknown bool
+	rules        map[string]FieldRule
+	noDir        bool
+	errorMessage string
+	err          error
+}{
+	{
+		name:         "incorrect type",
+		parts:        []formPart{{field: "age", value: "old"}, {field: "avatar", fileName: "me.png"}},
+		errorMessage: `form contains incorrect type for field "age"`,
+	},
+	{
+		name:         "unknown field",
+		parts:        []formPart{{field: "colour", value: "red"}, {field: "avatar", fileName: "me.png"}},
+		errorMessage: `form contains unknown field "colour"`,
+	},
+	{
+		name:         "unknown field allowed",
+		parts:        []formPart{{field: "colour", value: "red"}, {field: "avatar", fileName: "me.png"}},
+		allowUnknown: true,
+	},
+	{
+		name:         "unknown file field allowed",
+		parts:        []formPart{{field: "avatar", fileName: "me.png"}, {field: "cover", fileName: "cover.png"}},
+		allowUnknown: true,
+	},
+	{
+		name:         "unknown file field",
+		parts:        []formPart{{field: "avatar", fileName: "me.png"}, {field: "cover", fileName: "cover.png"}},
+		errorMessage: `form contains unknown file field "cover"`,
+	},
+	{
+		name:  "missing required file",
+		parts: []formPart{{field: "title", value: "me"}},
+		err:   ErrMissingField,
+	},
+	{
+		name:  "file over maxsize",
+		parts: []formPart{{field: "avatar", fileName: "me.png", value: "\x89PNG\r\n\x1a\n" + string(make([]byte, 2*1024*1024))}},
+		err:   ErrFileTooLarge,
+	},
+	{
+		name:  "more than one file",
+		parts: []formPart{{field: "avatar", fileName: "me.png"}, {field: "avatar", fileName: "you.png"}},
+		err:   ErrTooManyFiles,
+	},
+	{
+		name:  "configured rule kept",
+		parts: []formPart{{field: "avatar", fileName: "me.png"}},
+		rules: map[string]FieldRule{"gallery": {Required: true}},
+		err:   ErrMissingField,
+	},
+	{
+		name:         "no upload directory",
+		parts:        []formPart{{field: "avatar", fileName: "me.png"}},
+		noDir:        true,
+		errorMessage: "error binding form: an upload directory is required for file fields",
+	},
+}
+
+func TestTools_ReadMultipartErrors(t *testing.T) {
+	for _, e := range readMultipartTests {
+		body, contentType := newForm(t, e.parts...)
+
+		request := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+		request.Header.Add("Content-Type", contentType)
+
+		store := &MemoryStorage{}
+		testTools := Tools{Storage: store, AllowUnknownFields: e.allowUnknown, FieldRules: e.rules}
+
+		dir := "uploads"
+		if e.noDir {
+			dir = ""
+		}
+
+		var form profileForm
+
+		err := testTools.ReadMultipart(httptest.NewRecorder(), request, &form, dir)
+
+		if e.allowUnknown {
+			if err != nil {
+				t.Errorf("%s: unexpected error %s", e.name, err)
+			}
+
+			if files, _ := store.List(""); len(files) != 1 || form.Avatar == nil {
+				t.Errorf("%s: expected only the bound avatar to be stored, found %d files", e.name, len(files))
+			}
+
+			continue
+		}
+
+		if err == nil {
+			t.Errorf("%s: error expected but none received", e.name)
+			continue
+		}
+
+		if e.errorMessage != "" && err.Error() != e.errorMessage {
+			t.Errorf("%s: expected %q, got %q", e.name, e.errorMessage, err)
+		}
+
+		if e.err != nil && !errors.Is(err, e.err) {
+			t.Errorf("%s: expected %v, got %v", e.name, e.err, err)
+		}
+
+		if files, _ := store.List(""); len(files) != 0 {
+			t.Errorf("%s: expected uploads to be removed, found %d files", e.name, len(files))
+		}
+	}
+}
+
+func TestTools_ReadMultipartDestination(t *testing.T) {
+	var testTools Tools
+
+	var notAStruct string
+
+	request := httptest.NewRequest("POST", "/", nil)
+
+	if err := testTools.ReadMultipart(httptest.NewRecorder(), request, &notAStruct, "uploads"); err == nil {
+		t.Error("expected error for a destination that is not a struct pointer")
+	}
+
+	var badFile struct {
+		Avatar string `file:"avatar"`
+	}
+
+	if err := testTools.ReadMultipart(httptest.NewRecorder(), request, &badFile, "uploads"); err == nil {
+		t.Error("expected error for a file field of the wrong type")
+	}
+}
@@ -0,0 +1,83 @@
+package toolkit
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"hash/crc32"
+	"io"
+	"path"
+)
+
+// uploadHashes computes the checksums of an upload while it streams
+type uploadHashes struct {
+	sha256 hash.Hash
+	md5    hash.Hash
+	crc32c hash.Hash
+	w      io.Writer
+}
+
+func (t *Tools) newUploadHashes() *uploadHashes {
+	h := &uploadHashes{sha256: sha256.New()}
+	writers := []io.Writer{h.sha256}
+
+	if t.HashMD5 {
+		h.md5 = md5.New()
+		writers = append(writers, h.md5)
+	}
+
+	if t.HashCRC32C {
+		h.crc32c = crc32.New(crc32.MakeTable(crc32.Castagnoli))
+		writers = append(writers, h.crc32c)
+	}
+
+	h.w = io.MultiWriter(writers...)
+
+	return h
+}
+
+func (h *uploadHashes) Write(p []byte) (int, error) {
+	return h.w.Write(p)
+}
+
+// apply records the hex encoded checksums on f
+func (h *uploadHashes) apply(f *UploadedFile) {
+	f.SHA256 = hex.EncodeToString(h.sha256.Sum(nil))
+
+	if h.md5 != nil {
+		f.MD5 = hex.EncodeToString(h.md5.Sum(nil))
+	}
+
+	if h.crc32c != nil {
+		f.CRC32C = hex.EncodeToString(h.crc32c.Sum(nil))
+	}
+}
+
+// storeContentAddressed moves a file uploaded to the temporary name tmp
+// to uploadDir/<sha256><ext>, discarding it if that file already exists.
+func (t *Tools) storeContentAddressed(tmp, uploadDir, ext string, f *UploadedFile) error {
+	store := t.storage()
+
+	f.NewFileName = f.SHA256 + ext
+	dst := path.Join(uploadDir, f.NewFileName)
+
+	exists, err := storedFileExists(store, dst)
+
+	if err != nil {
+		_ = store.Delete(tmp)
+		return err
+	}
+
+	if exists {
+		f.Duplicate = true
+		return store.Delete(tmp)
+	}
+
+	if err := moveFile(store, tmp, dst); err != nil {
+		_ = store.Delete(tmp)
+		return err
+	}
+
+	return nil
+}
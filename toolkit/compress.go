@@ -0,0 +1,358 @@
+package toolkit
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Encoding is a content coding responses can be compressed with. Name is
+// the Content-Encoding token and Extension the suffix of precompressed
+// siblings, such as app.js.br next to app.js. NewWriter compresses on
+// the fly; without it only precompressed siblings are served.
+type Encoding struct {
+	Name      string
+	Extension string
+	NewWriter func(w io.Writer) io.WriteCloser
+}
+
+// defaultEncodings serves brotli and zstd siblings when present and
+// compresses everything else with gzip
+var defaultEncodings = []Encoding{
+	{Name: "br", Extension: ".br"},
+	{Name: "zstd", Extension: ".zst"},
+	{Name: "gzip", Extension: ".gz", NewWriter: func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }},
+}
+
+// incompressibleTypes are already compressed, or too opaque to be worth compressing
+var incompressibleTypes = map[string]bool{
+	"application/octet-stream":     true,
+	"application/zip":              true,
+	"application/gzip":             true,
+	"application/x-gzip":           true,
+	"application/zstd":             true,
+	"application/x-bzip2":          true,
+	"application/x-xz":             true,
+	"application/x-7z-compressed":  true,
+	"application/vnd.rar":          true,
+	"application/x-rar-compressed": true,
+	"application/pdf":              true,
+	"application/epub+zip":         true,
+	"text/event-stream":            true,
+}
+
+// compressible reports whether responses of contentType are worth compressing
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+
+	if err != nil || incompressibleTypes[mediaType] {
+		return false
+	}
+
+	switch {
+	case strings.HasSuffix(mediaType, "+json"), strings.HasSuffix(mediaType, "+xml"):
+		return true
+	case strings.HasPrefix(mediaType, "image/"), strings.HasPrefix(mediaType, "video/"),
+		strings.HasPrefix(mediaType, "audio/"), strings.HasPrefix(mediaType, "font/woff"),
+		strings.HasPrefix(mediaType, "application/vnd.openxmlformats"),
+		strings.HasPrefix(mediaType, "application/vnd.oasis.opendocument"):
+		return false
+	}
+
+	return true
+}
+
+// acceptedEncodings returns the configured Encodings the client accepts,
+// most preferred first. Encodings the client rates equally keep their
+// configured order.
+func (t *Tools) acceptedEncodings(r *http.Request) []Encoding {
+	encodings := t.Encodings
+	if encodings == nil {
+		encodings = defaultEncodings
+	}
+
+	quality := make(map[string]float64)
+
+	for _, field := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(field), ";")
+		q := 1.0
+
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+
+		if name != "" {
+			quality[strings.ToLower(name)] = q
+		}
+	}
+
+	var accepted []Encoding
+
+	for _, encoding := range encodings {
+		q, ok := quality[encoding.Name]
+		if !ok {
+			q = quality["*"]
+		}
+
+		if q > 0 {
+			accepted = append(accepted, encoding)
+		}
+	}
+
+	sort.SliceStable(accepted, func(i, j int) bool {
+		qi, ok := quality[accepted[i].Name]
+		if !ok {
+			qi = quality["*"]
+		}
+
+		qj, ok := quality[accepted[j].Name]
+		if !ok {
+			qj = quality["*"]
+		}
+
+		return qi > qj
+	})
+
+	return accepted
+}
+
+// encoder returns the preferred accepted Encoding that can compress on the fly
+func (t *Tools) encoder(r *http.Request) (Encoding, bool) {
+	for _, encoding := range t.acceptedEncodings(r) {
+		if encoding.NewWriter != nil {
+			return encoding, true
+		}
+	}
+
+	return Encoding{}, false
+}
+
+// precompressed opens the preferred precompressed sibling of file in dir
+// that the client accepts. Siblings are jailed to dir like file itself.
+// Range requests get none, as their offsets refer to the uncompressed file.
+func (t *Tools) precompressed(r *http.Request, dir, file string) (io.ReadSeekCloser, *StoredFile, Encoding, bool) {
+	if r.Header.Get("Range") != "" {
+		return nil, nil, Encoding{}, false
+	}
+
+	store := t.storage()
+
+	for _, encoding := range t.acceptedEncodings(r) {
+		if encoding.Extension == "" {
+			continue
+		}
+
+		name, err := t.jail(dir, file+encoding.Extension)
+
+		if err != nil {
+			continue
+		}
+
+		info, err := store.Stat(name)
+
+		if err != nil {
+			continue
+		}
+
+		f, err := store.Get(name)
+
+		if err != nil {
+			continue
+		}
+
+		return f, info, encoding, true
+	}
+
+	return nil, nil, Encoding{}, false
+}
+
+// contentTypeOf returns the type of content from the extension of name,
+// sniffing the first bytes when the extension is unknown
+func contentTypeOf(name string, content io.ReadSeeker) (string, error) {
+	if byExtension := mime.TypeByExtension(path.Ext(name)); byExtension != "" {
+		return byExtension, nil
+	}
+
+	buff := make([]byte, 512)
+	n, err := io.ReadFull(content, buff)
+
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+
+	if _, err = content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	return http.DetectContentType(buff[:n]), nil
+}
+
+// serveContent is http.ServeContent, compressing compressible content
+// on the fly when the client accepts one of the configured Encodings.
+// Range requests are answered from the uncompressed content.
+func (t *Tools) serveContent(w http.ResponseWriter, r *http.Request, name string, modtime time.Time, content io.ReadSeeker) {
+	if w.Header().Get("Content-Encoding") != "" {
+		http.ServeContent(w, r, name, modtime, content)
+		return
+	}
+
+	if w.Header().Get("Content-Type") == "" {
+		detected, err := contentTypeOf(name, content)
+
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", detected)
+	}
+
+	encoding, ok := t.encoder(r)
+
+	if !ok || r.Header.Get("Range") != "" || !compressible(w.Header().Get("Content-Type")) {
+		if compressible(w.Header().Get("Content-Type")) {
+			addVary(w.Header(), "Accept-Encoding")
+		}
+
+		http.ServeContent(w, r, name, modtime, content)
+		return
+	}
+
+	// the compressed representation needs its own entity tag, and it has
+	// to be in place before ServeContent evaluates the request preconditions
+	if etag := w.Header().Get("ETag"); etag != "" {
+		w.Header().Set("ETag", encodedETag(etag, encoding.Name))
+	}
+
+	cw := &compressWriter{ResponseWriter: w, encoding: encoding, keepETag: true}
+	defer cw.Close()
+
+	http.ServeContent(cw, r, name, modtime, content)
+}
+
+// CompressResponses wraps next so compressible responses, such as those
+// written by WriteJSON and ErrorJSON, are compressed with the preferred
+// Encoding the client accepts. The JSON writers do not see the request,
+// so this is how their responses are compressed.
+func (t *Tools) CompressResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding, _ := t.encoder(r)
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.Close()
+
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// compressWriter compresses the body of a response once its headers show
+// it is compressible. Without an encoding it only adds the Vary header.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding    Encoding
+	keepETag    bool
+	wroteHeader bool
+	compress    bool
+	writer      io.WriteCloser
+}
+
+func (c *compressWriter) WriteHeader(status int) {
+	if c.wroteHeader || status < http.StatusOK {
+		c.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	c.wroteHeader = true
+	h := c.Header()
+
+	if compressible(h.Get("Content-Type")) {
+		addVary(h, "Accept-Encoding")
+
+		if c.encoding.NewWriter != nil && h.Get("Content-Encoding") == "" && h.Get("Content-Range") == "" &&
+			status >= http.StatusOK && status != http.StatusNoContent && status != http.StatusPartialContent {
+			c.compress = true
+
+			h.Del("Content-Length")
+			h.Set("Content-Encoding", c.encoding.Name)
+
+			if etag := h.Get("ETag"); etag != "" && !c.keepETag {
+				h.Set("ETag", encodedETag(etag, c.encoding.Name))
+			}
+		}
+	}
+
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *compressWriter) Write(p []byte) (int, error) {
+	if !c.wroteHeader {
+		if c.Header().Get("Content-Type") == "" {
+			c.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+
+		c.WriteHeader(http.StatusOK)
+	}
+
+	if !c.compress {
+		return c.ResponseWriter.Write(p)
+	}
+
+	if c.writer == nil {
+		c.writer = c.encoding.NewWriter(c.ResponseWriter)
+	}
+
+	return c.writer.Write(p)
+}
+
+// Flush sends whatever has been compressed so far
+func (c *compressWriter) Flush() {
+	if flusher, ok := c.writer.(interface{ Flush() error }); ok {
+		_ = flusher.Flush()
+	}
+
+	if flusher, ok := c.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (c *compressWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+func (c *compressWriter) Close() error {
+	if c.writer == nil {
+		return nil
+	}
+
+	return c.writer.Close()
+}
+
+// encodedETag marks an entity tag as belonging to an encoded representation
+func encodedETag(etag, encoding string) string {
+	if strings.HasSuffix(etag, `"`) {
+		return strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+	}
+
+	return etag
+}
+
+// addVary adds value to the Vary header unless it is already listed
+func addVary(h http.Header, value string) {
+	for _, v := range h.Values("Vary") {
+		for _, field := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), value) {
+				return
+			}
+		}
+	}
+
+	h.Add("Vary", value)
+}
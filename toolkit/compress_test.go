@@ -0,0 +1,222 @@
+package toolkit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func gunzip(t *testing.T, data []byte) string {
+	gr, err := gzip.NewReader(bytes.NewReader(data))
+
+	if err != nil {
+		t.Fatalf("response is not gzip: %s", err)
+	}
+
+	out, _ := io.ReadAll(gr)
+
+	return string(out)
+}
+
+func TestTools_DownloadReaderCompression(t *testing.T) {
+	content := strings.Repeat("compress me please ", 100)
+	modTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var testTools Tools
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+
+	rr := httptest.NewRecorder()
+	testTools.DownloadReader(rr, req, "notes.txt", modTime, strings.NewReader(content))
+
+	if rr.Header().Get("Content-Encoding") != "gzip" || rr.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("expected gzip response, got headers %v", rr.Header())
+	}
+
+	if rr.Header().Get("Content-Length") != "" || gunzip(t, rr.Body.Bytes()) != content {
+		t.Error("unexpected compressed body")
+	}
+
+	etag := rr.Header().Get("ETag")
+
+	if !strings.HasSuffix(etag, `-gzip"`) {
+		t.Errorf("expected etag of the compressed representation, got %s", etag)
+	}
+
+	req.Header.Set("If-None-Match", etag)
+
+	rr = httptest.NewRecorder()
+	testTools.DownloadReader(rr, req, "notes.txt", modTime, strings.NewReader(content))
+
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for compressed etag, got %d", rr.Code)
+	}
+
+	req.Header.Del("If-None-Match")
+	req.Header.Set("Range", "bytes=0-7")
+
+	rr = httptest.NewRecorder()
+	testTools.DownloadReader(rr, req, "notes.txt", modTime, strings.NewReader(content))
+
+	if rr.Code != http.StatusPartialContent || rr.Header().Get("Content-Encoding") != "" || rr.Body.String() != "compress" {
+		t.Errorf("expected uncompressed range, got %d %q", rr.Code, rr.Body.String())
+	}
+}
+
+func TestTools_DownloadStaticFileSkipsCompressedTypes(t *testing.T) {
+	var testTools Tools
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	rr := httptest.NewRecorder()
+	testTools.DownloadStaticFile(rr, req, "testdata", "pic.jpg", "puppy.jpg")
+
+	if rr.Header().Get("Content-Encoding") != "" || rr.Header().Get("Content-Length") != "98827" {
+		t.Errorf("expected jpeg to be sent as is, got headers %v", rr.Header())
+	}
+}
+
+var precompressedTests = []struct {
+	name           string
+	acceptEncoding string
+	encoding       string
+	body           string
+}{
+	{name: "brotli preferred", acceptEncoding: "gzip, deflate, br", encoding: "br", body: "brotli bytes"},
+	{name: "gzip only", acceptEncoding: "gzip", encoding: "gzip", body: "gzip bytes"},
+	{name: "quality values", acceptEncoding: "br;q=0.5, gzip", encoding: "gzip", body: "gzip bytes"},
+	{name: "brotli refused", acceptEncoding: "*, br;q=0", encoding: "gzip", body: "gzip bytes"},
+	{name: "no encodings", acceptEncoding: "", encoding: "", body: "console.log(1)"},
+}
+
+func TestTools_DownloadStaticFilePrecompressed(t *testing.T) {
+	store := &MemoryStorage{}
+
+	_, _ = store.Put("site/app.js", strings.NewReader("console.log(1)"))
+	_, _ = store.Put("site/app.js.br", strings.NewReader("brotli bytes"))
+	_, _ = store.Put("site/app.js.gz", strings.NewReader("gzip bytes"))
+
+	testTools := Tools{Storage: store}
+
+	for _, e := range precompressedTests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", e.acceptEncoding)
+
+		rr := httptest.NewRecorder()
+		testTools.DownloadStaticFile(rr, req, "site", "app.js", "app.js")
+
+		if rr.Header().Get("Content-Encoding") != e.encoding || rr.Body.String() != e.body {
+			t.Errorf("%s: expected %q encoded %q, got %q encoded %q", e.name, e.encoding, e.body, rr.Header().Get("Content-Encoding"), rr.Body.String())
+		}
+
+		if !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/javascript") {
+			t.Errorf("%s: expected javascript content type, got %s", e.name, rr.Header().Get("Content-Type"))
+		}
+	}
+}
+
+func TestTools_DownloadStaticFilePrecompressedJailed(t *testing.T) {
+	root := t.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(root, "files"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = os.WriteFile(filepath.Join(root, "files", "a.txt"), []byte("public"), 0644)
+	_ = os.WriteFile(filepath.Join(root, "secret.gz"), []byte("secret"), 0644)
+
+	if err := os.Symlink(filepath.Join(root, "secret.gz"), filepath.Join(root, "files", "a.txt.gz")); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+
+	testTools := Tools{Storage: LocalStorage{Root: root}}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	rr := httptest.NewRecorder()
+	testTools.DownloadStaticFile(rr, req, "files", "a.txt", "a.txt")
+
+	if rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), "secret") {
+		t.Errorf("expected sibling outside the directory to be ignored, got %d %q", rr.Code, rr.Body.String())
+	}
+
+	if rr.Header().Get("Content-Encoding") == "gzip" && gunzip(t, rr.Body.Bytes()) != "public" {
+		t.Errorf("expected the public file, got %q", gunzip(t, rr.Body.Bytes()))
+	}
+}
+
+func TestTools_CompressResponses(t *testing.T) {
+	var testTools Tools
+
+	payload := map[string]string{"message": strings.Repeat("hello ", 100)}
+
+	handler := testTools.CompressResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/empty" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		_ = testTools.WriteJSON(w, http.StatusOK, payload)
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Header().Get("Content-Encoding") != "gzip" || !strings.Contains(gunzip(t, rr.Body.Bytes()), "hello hello") {
+		t.Errorf("expected compressed JSON, got headers %v", rr.Header())
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	if rr.Header().Get("Content-Encoding") != "" || rr.Header().Get("Vary") != "Accept-Encoding" || !strings.Contains(rr.Body.String(), "hello") {
+		t.Errorf("expected plain JSON with Vary header, got headers %v", rr.Header())
+	}
+
+	req = httptest.NewRequest("GET", "/empty", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNoContent || rr.Header().Get("Content-Encoding") != "" || rr.Body.Len() != 0 {
+		t.Errorf("expected empty uncompressed response, got %d with %v", rr.Code, rr.Header())
+	}
+}
+
+func TestTools_DownloadStaticFilePrecompressedRange(t *testing.T) {
+	store := &MemoryStorage{}
+
+	_, _ = store.Put("site/app.js", strings.NewReader("console.log(1)"))
+	_, _ = store.Put("site/app.js.gz", strings.NewReader("gzip bytes"))
+
+	testTools := Tools{Storage: store}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Range", "bytes=0-6")
+
+	rr := httptest.NewRecorder()
+	testTools.DownloadStaticFile(rr, req, "site", "app.js", "app.js")
+
+	if rr.Code != http.StatusPartialContent || rr.Header().Get("Content-Encoding") != "" || rr.Body.String() != "console" {
+		t.Errorf("expected the uncompressed range, got %d %q encoded %q", rr.Code, rr.Body.String(), rr.Header().Get("Content-Encoding"))
+	}
+
+	if rr.Header().Get("Content-Range") != "bytes 0-6/14" {
+		t.Errorf("unexpected Content-Range %s", rr.Header().Get("Content-Range"))
+	}
+}
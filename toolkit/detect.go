@@ -0,0 +1,196 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/http"
+	"strings"
+)
+
+// Detector works out the content type of an upload from its first bytes
+type Detector interface {
+	Detect(data []byte) string
+}
+
+// DetectorFunc adapts an ordinary function, such as http.DetectContentType,
+// to the Detector interface.
+type DetectorFunc func(data []byte) string
+
+func (f DetectorFunc) Detect(data []byte) string {
+	return f(data)
+}
+
+// detector returns the configured Detector, defaulting to http.DetectContentType
+func (t *Tools) detector() Detector {
+	if t.Detector != nil {
+		return t.Detector
+	}
+
+	return DetectorFunc(http.DetectContentType)
+}
+
+// Signature identifies a content type by the bytes found at Offset
+type Signature struct {
+	Offset      int
+	Magic       []byte
+	ContentType string
+}
+
+// extendedSignatures covers common formats http.DetectContentType reports
+// as application/octet-stream.
+var extendedSignatures = []Signature{
+	{Offset: 4, Magic: []byte("ftypheic"), ContentType: "image/heic"},
+	{Offset: 4, Magic: []byte("ftypheix"), ContentType: "image/heic"},
+	{Offset: 4, Magic: []byte("ftyphevc"), ContentType: "image/heic"},
+	{Offset: 4, Magic: []byte("ftyphevx"), ContentType: "image/heic"},
+	{Offset: 4, Magic: []byte("ftypmif1"), ContentType: "image/heif"},
+	{Offset: 4, Magic: []byte("ftypmsf1"), ContentType: "image/heif"},
+	{Offset: 4, Magic: []byte("ftypavif"), ContentType: "image/avif"},
+	{Offset: 4, Magic: []byte("ftypavis"), ContentType: "image/avif"},
+	{Offset: 4, Magic: []byte("ftypqt  "), ContentType: "video/quicktime"},
+	{Offset: 4, Magic: []byte("ftypM4A "), ContentType: "audio/mp4"},
+	{Offset: 4, Magic: []byte("ftyp3gp"), ContentType: "video/3gpp"},
+	{Offset: 0, Magic: []byte("PAR1"), ContentType: "application/vnd.apache.parquet"},
+	{Offset: 0, Magic: []byte("\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1"), ContentType: "application/x-ole-storage"},
+	{Offset: 0, Magic: []byte("7z\xBC\xAF\x27\x1C"), ContentType: "application/x-7z-compressed"},
+	{Offset: 0, Magic: []byte("\xFD7zXZ\x00"), ContentType: "application/x-xz"},
+	{Offset: 0, Magic: []byte("\x28\xB5\x2F\xFD"), ContentType: "application/zstd"},
+	{Offset: 257, Magic: []byte("ustar"), ContentType: "application/x-tar"},
+	{Offset: 0, Magic: []byte("II*\x00"), ContentType: "image/tiff"},
+	{Offset: 0, Magic: []byte("MM\x00*"), ContentType: "image/tiff"},
+	{Offset: 0, Magic: []byte("8BPS"), ContentType: "image/vnd.adobe.photoshop"},
+	{Offset: 0, Magic: []byte("fLaC"), ContentType: "audio/flac"},
+	{Offset: 0, Magic: []byte("SQLite format 3\x00"), ContentType: "application/vnd.sqlite3"},
+	{Offset: 0, Magic: []byte(`{\rtf`), ContentType: "application/rtf"},
+	{Offset: 0, Magic: []byte("\x7FELF"), ContentType: "application/x-elf"},
+}
+
+// zipContainers maps an entry found inside a zip archive to the format
+// that archive holds.
+var zipContainers = []struct {
+	entry       string
+	contentType string
+}{
+	{entry: "word/", contentType: "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+	{entry: "xl/", contentType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+	{entry: "ppt/", contentType: "application/vnd.openxmlformats-officedocument.presentationml.presentation"},
+	{entry: "META-INF/MANIFEST.MF", contentType: "application/java-archive"},
+	{entry: "AndroidManifest.xml", contentType: "application/vnd.android.package-archive"},
+}
+
+// ExtendedDetector recognises the formats in its bundled signature table,
+// Office and OpenDocument files inside zip containers and Matroska/WebM
+// video, before falling back to http.DetectContentType. Signatures are
+// checked ahead of the bundled table.
+type ExtendedDetector struct {
+	Signatures []Signature
+}
+
+func (d ExtendedDetector) Detect(data []byte) string {
+	for _, table := range [][]Signature{d.Signatures, extendedSignatures} {
+		for _, sig := range table {
+			if len(data) >= sig.Offset+len(sig.Magic) && bytes.Equal(data[sig.Offset:sig.Offset+len(sig.Magic)], sig.Magic) {
+				return sig.ContentType
+			}
+		}
+	}
+
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return detectZip(data)
+	}
+
+	if isBzip2(data) {
+		return "application/x-bzip2"
+	}
+
+	if isPortableExecutable(data) {
+		return "application/vnd.microsoft.portable-executable"
+	}
+
+	if bytes.HasPrefix(data, []byte("\x1A\x45\xDF\xA3")) {
+		if bytes.Contains(data[:min(len(data), 64)], []byte("webm")) {
+			return "video/webm"
+		}
+		return "video/x-matroska"
+	}
+
+	return http.DetectContentType(data)
+}
+
+// detectZip reads the local file headers in data and reports the
+// container format they belong to, or application/zip.
+func detectZip(data []byte) string {
+	const headerLen = 30
+
+	for offset := 0; ; {
+		i := bytes.Index(data[offset:], []byte("PK\x03\x04"))
+
+		if i < 0 || offset+i+headerLen > len(data) {
+			return "application/zip"
+		}
+
+		header := data[offset+i:]
+		compressedSize := int(binary.LittleEndian.Uint32(header[18:]))
+		nameLen := int(binary.LittleEndian.Uint16(header[26:]))
+		extraLen := int(binary.LittleEndian.Uint16(header[28:]))
+
+		if headerLen+nameLen > len(header) {
+			return "application/zip"
+		}
+
+		name := string(header[headerLen : headerLen+nameLen])
+
+		// OpenDocument and EPUB store their type uncompressed in "mimetype";
+		// streamed archives leave the size at zero and use a data descriptor
+		if name == "mimetype" {
+			content := header[min(headerLen+nameLen+extraLen, len(header)):]
+
+			if end := bytes.Index(content, []byte("PK")); compressedSize == 0 && end >= 0 {
+				content = content[:end]
+			} else if compressedSize <= len(content) {
+				content = content[:compressedSize]
+			}
+
+			// the entry is written by whoever made the archive, so only the
+			// formats that use it are believed
+			mediaType := strings.TrimSpace(string(content))
+
+			if strings.HasPrefix(mediaType, "application/vnd.oasis.opendocument.") || mediaType == "application/epub+zip" {
+				return mediaType
+			}
+
+			return "application/zip"
+		}
+
+		for _, c := range zipContainers {
+			if strings.HasPrefix(name, c.entry) {
+				return c.contentType
+			}
+		}
+
+		offset += i + headerLen + nameLen
+	}
+}
+
+// isBzip2 checks the "BZh" signature together with the block size digit
+// and the magic of the first block, or of the end of an empty stream
+func isBzip2(data []byte) bool {
+	if len(data) < 10 || !bytes.HasPrefix(data, []byte("BZh")) || data[3] < '1' || data[3] > '9' {
+		return false
+	}
+
+	return bytes.Equal(data[4:10], []byte("\x31\x41\x59\x26\x53\x59")) ||
+		bytes.Equal(data[4:10], []byte("\x17\x72\x45\x38\x50\x90"))
+}
+
+// isPortableExecutable follows e_lfanew in the "MZ" DOS header to the
+// "PE\0\0" signature of a Windows executable
+func isPortableExecutable(data []byte) bool {
+	if len(data) < 0x40 || !bytes.HasPrefix(data, []byte("MZ")) {
+		return false
+	}
+
+	offset := int(binary.LittleEndian.Uint32(data[0x3c:]))
+
+	return offset >= 0x40 && offset+4 <= len(data) && bytes.Equal(data[offset:offset+4], []byte("PE\x00\x00"))
+}
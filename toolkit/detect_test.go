@@ -0,0 +1,118 @@
+package toolkit
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http/httptest"
+	"testing"
+)
+
+// newZip builds an archive holding the named entries, storing
+// "mimetype" uncompressed the way OpenDocument and EPUB do.
+func newZip(t *testing.T, entries map[string]string, order ...string) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+
+	for _, name := range order {
+		method := zip.Deflate
+		if name == "mimetype" {
+			method = zip.Store
+		}
+
+		f, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: method})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, _ = f.Write([]byte(entries[name]))
+	}
+
+	w.Close()
+
+	return buf.Bytes()
+}
+
+func TestExtendedDetector_Detect(t *testing.T) {
+	docx := newZip(t, map[string]string{"[Content_Types].xml": "<Types/>", "word/document.xml": "<w:document/>"},
+		"[Content_Types].xml", "word/document.xml")
+	xlsx := newZip(t, map[string]string{"[Content_Types].xml": "<Types/>", "xl/workbook.xml": "<workbook/>"},
+		"[Content_Types].xml", "xl/workbook.xml")
+	odt := newZip(t, map[string]string{"mimetype": "application/vnd.oasis.opendocument.text", "content.xml": "<office/>"},
+		"mimetype", "content.xml")
+	plainZip := newZip(t, map[string]string{"notes.txt": "hello"}, "notes.txt")
+	fakeMimetype := newZip(t, map[string]string{"mimetype": "image/png", "evil.html": "<script>"}, "mimetype", "evil.html")
+
+	pe := make([]byte, 0x80)
+	copy(pe, "MZ")
+	pe[0x3c] = 0x40
+	copy(pe[0x40:], "PE\x00\x00")
+
+	tar := make([]byte, 512)
+	copy(tar[257:], "ustar")
+
+	var detectTests = []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{name: "docx", data: docx, expected: "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+		{name: "xlsx", data: xlsx, expected: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+		{name: "odt", data: odt, expected: "application/vnd.oasis.opendocument.text"},
+		{name: "zip", data: plainZip, expected: "application/zip"},
+		{name: "zip with untrusted mimetype", data: fakeMimetype, expected: "application/zip"},
+		{name: "windows executable", data: pe, expected: "application/vnd.microsoft.portable-executable"},
+		{name: "text starting with MZ", data: []byte("MZ,city,population\nBerlin,3.6m\n"), expected: "text/plain; charset=utf-8"},
+		{name: "bzip2", data: []byte("BZh91AY&SY\x00\x00"), expected: "application/x-bzip2"},
+		{name: "text starting with BZh", data: []byte("BZh what a name\n"), expected: "text/plain; charset=utf-8"},
+		{name: "heic", data: []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00"), expected: "image/heic"},
+		{name: "parquet", data: []byte("PAR1\x15\x04"), expected: "application/vnd.apache.parquet"},
+		{name: "webm", data: []byte("\x1A\x45\xDF\xA3\x9F\x42\x86\x81\x01\x42\x82\x84webm"), expected: "video/webm"},
+		{name: "mkv", data: []byte("\x1A\x45\xDF\xA3\x9F\x42\x86\x81\x01\x42\x82\x88matroska"), expected: "video/x-matroska"},
+		{name: "tar", data: tar, expected: "application/x-tar"},
+		{name: "fallback png", data: []byte("\x89PNG\r\n\x1a\n"), expected: "image/png"},
+	}
+
+	var d ExtendedDetector
+
+	for _, e := range detectTests {
+		if contentType := d.Detect(e.data); contentType != e.expected {
+			t.Errorf("%s: expected %s got %s", e.name, e.expected, contentType)
+		}
+	}
+
+	custom := ExtendedDetector{Signatures: []Signature{{Magic: []byte("PAR1"), ContentType: "application/x-custom"}}}
+
+	if contentType := custom.Detect([]byte("PAR1")); contentType != "application/x-custom" {
+		t.Errorf("expected custom signature to take precedence, got %s", contentType)
+	}
+}
+
+func TestTools_UploadFilesDetector(t *testing.T) {
+	docx := newZip(t, map[string]string{"[Content_Types].xml": "<Types/>", "word/document.xml": "<w:document/>"},
+		"[Content_Types].xml", "word/document.xml")
+
+	for _, detector := range []Detector{nil, ExtendedDetector{}} {
+		var body bytes.Buffer
+		contentType := multipartFile(t, &body, "report.docx", docx)
+
+		request := httptest.NewRequest("POST", "/", &body)
+		request.Header.Add("Content-Type", contentType)
+
+		testTools := Tools{
+			Storage:          &MemoryStorage{},
+			Detector:         detector,
+			AllowedFileTypes: []string{"application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+		}
+
+		_, err := testTools.UploadOneFile(request, "uploads")
+
+		if detector == nil && err == nil {
+			t.Error("expected default detector to report docx as a zip")
+		}
+
+		if detector != nil && err != nil {
+			t.Errorf("expected extended detector to allow docx: %s", err)
+		}
+	}
+}
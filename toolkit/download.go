@@ -0,0 +1,210 @@
+package toolkit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Disposition chooses whether browsers save downloads or display them
+type Disposition int
+
+const (
+	// DispositionAttachment asks the browser to save the file
+	DispositionAttachment Disposition = iota
+	// DispositionInline lets the browser display the file when it can
+	DispositionInline
+)
+
+func (d Disposition) String() string {
+	if d == DispositionInline {
+		return "inline"
+	}
+
+	return "attachment"
+}
+
+// DownloadReader sends content as a download named name, for files kept
+// in databases, object storage or anywhere else that can provide an
+// io.ReadSeeker. Range and conditional requests are supported, so
+// interrupted downloads resume correctly. Unless the ETag header is
+// already set, it is a SHA-256 of content; see WeakETags.
+func (t *Tools) DownloadReader(w http.ResponseWriter, r *http.Request, name string, modtime time.Time, content io.ReadSeeker) {
+	w, audit := t.auditDownload(w, r, name, name)
+	defer audit.finish()
+
+	w, done, ok := t.startDownload(w, r)
+
+	if !ok {
+		return
+	}
+
+	defer done()
+
+	t.downloadReader(w, r, name, modtime, content)
+}
+
+// downloadReader is DownloadReader for callers that already started the download
+func (t *Tools) downloadReader(w http.ResponseWriter, r *http.Request, name string, modtime time.Time, content io.ReadSeeker) {
+	disposition, err := ContentDisposition(t.Disposition, name)
+
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if w.Header().Get("ETag") == "" {
+		etag, err := t.etag(content, modtime)
+
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		if etag != "" {
+			w.Header().Set("ETag", etag)
+		}
+	}
+
+	w.Header().Set("Content-Disposition", disposition)
+
+	t.serveContent(w, r, name, modtime, content)
+}
+
+// jailedStorage is implemented by storages that can tell whether a name
+// resolves, through symlinks or otherwise, to somewhere outside dir.
+type jailedStorage interface {
+	within(dir, name string) error
+}
+
+// jail returns the storage name of file inside dir. Names that are not
+// plain relative paths, that climb out of dir or that contain a hidden
+// segment are reported as not existing.
+func (t *Tools) jail(dir, file string) (string, error) {
+	notExist := &fs.PathError{Op: "open", Path: file, Err: fs.ErrNotExist}
+
+	if !fs.ValidPath(file) || strings.ContainsAny(file, "\\\x00") {
+		return "", notExist
+	}
+
+	if hiddenPath(file) {
+		return "", notExist
+	}
+
+	if store, ok := t.storage().(jailedStorage); ok {
+		if err := store.within(dir, file); err != nil {
+			return "", notExist
+		}
+	}
+
+	return path.Join(dir, file), nil
+}
+
+// etag returns the entity tag of content: a strong SHA-256 of it, or
+// with WeakETags a weak tag built from its size and modtime, left out
+// when modtime is unknown.
+func (t *Tools) etag(content io.ReadSeeker, modtime time.Time) (string, error) {
+	if !t.WeakETags {
+		return contentETag(content)
+	}
+
+	if modtime.IsZero() || modtime.Equal(time.Unix(0, 0)) {
+		return "", nil
+	}
+
+	size, err := content.Seek(0, io.SeekEnd)
+
+	if err != nil {
+		return "", err
+	}
+
+	if _, err = content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf(`W/"%x-%x"`, modtime.UnixNano(), size), nil
+}
+
+// contentETag hashes content and rewinds it
+func contentETag(content io.ReadSeeker) (string, error) {
+	hash := sha256.New()
+
+	if _, err := io.Copy(hash, content); err != nil {
+		return "", err
+	}
+
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	return `"` + hex.EncodeToString(hash.Sum(nil)) + `"`, nil
+}
+
+// ContentDisposition builds a Content-Disposition header value for
+// fileName following RFC 6266. Plain ASCII names are sent as a quoted
+// filename parameter. Other names get an ASCII fallback in filename for
+// old clients plus the exact name, RFC 5987 encoded, in filename*. Names
+// containing control characters or invalid UTF-8, which could inject
+// headers, are rejected with an *UnsafeFileNameError.
+func ContentDisposition(disposition Disposition, fileName string) (string, error) {
+	if fileName == "" || !utf8.ValidString(fileName) || strings.ContainsFunc(fileName, isControl) {
+		return "", &UnsafeFileNameError{FileName: strings.ToValidUTF8(strings.Map(dropControl, fileName), "")}
+	}
+
+	var fallback strings.Builder
+	plain := true
+
+	for _, c := range fileName {
+		switch {
+		case c == '"' || c == '\\' || c > '~':
+			fallback.WriteByte('_')
+			plain = false
+		default:
+			fallback.WriteRune(c)
+		}
+	}
+
+	value := fmt.Sprintf("%s; filename=\"%s\"", disposition, fallback.String())
+
+	if !plain {
+		value += "; filename*=UTF-8''" + encodeRFC5987(fileName)
+	}
+
+	return value, nil
+}
+
+func isControl(c rune) bool {
+	return c < ' ' || c == 0x7f
+}
+
+func dropControl(c rune) rune {
+	if isControl(c) {
+		return -1
+	}
+
+	return c
+}
+
+// encodeRFC5987 percent-encodes every byte that is not an attr-char
+func encodeRFC5987(s string) string {
+	var b strings.Builder
+
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			strings.IndexByte("!#$&+-.^_`|~", c) >= 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,306 @@
+package toolkit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const downloadContent = "0123456789abcdefghij"
+
+var downloadTests = []struct {
+	name        string
+	headers     map[string]string
+	status      int
+	body        string
+	contentType string
+}{
+	{name: "full download", status: http.StatusOK, body: downloadContent},
+	{name: "single range", headers: map[string]string{"Range": "bytes=10-14"}, status: http.StatusPartialContent, body: "abcde"},
+	{name: "resume from offset", headers: map[string]string{"Range": "bytes=15-"}, status: http.StatusPartialContent, body: "fghij"},
+	{name: "multiple ranges", headers: map[string]string{"Range": "bytes=0-1,18-19"}, status: http.StatusPartialContent, contentType: "multipart/byteranges"},
+	{name: "unsatisfiable range", headers: map[string]string{"Range": "bytes=50-60"}, status: http.StatusRequestedRangeNotSatisfiable},
+	{name: "matching etag", headers: map[string]string{"If-None-Match": "ETAG"}, status: http.StatusNotModified},
+	{name: "stale etag", headers: map[string]string{"If-None-Match": `"stale"`}, status: http.StatusOK, body: downloadContent},
+	{name: "not modified since", headers: map[string]string{"If-Modified-Since": "Sat, 01 Jan 2050 00:00:00 GMT"}, status: http.StatusNotModified},
+	{name: "modified since", headers: map[string]string{"If-Modified-Since": "Sat, 01 Jan 2000 00:00:00 GMT"}, status: http.StatusOK, body: downloadContent},
+	{name: "if-range with current etag", headers: map[string]string{"Range": "bytes=0-3", "If-Range": "ETAG"}, status: http.StatusPartialContent, body: "0123"},
+	{name: "if-range with stale etag", headers: map[string]string{"Range": "bytes=0-3", "If-Range": `"stale"`}, status: http.StatusOK, body: downloadContent},
+}
+
+func TestTools_DownloadReader(t *testing.T) {
+	hash := sha256.Sum256([]byte(downloadContent))
+	etag := `"` + hex.EncodeToString(hash[:]) + `"`
+	modTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var testTools Tools
+
+	for _, e := range downloadTests {
+		req := httptest.NewRequest("GET", "/", nil)
+
+		for k, v := range e.headers {
+			req.Header.Set(k, strings.ReplaceAll(v, "ETAG", etag))
+		}
+
+		rr := httptest.NewRecorder()
+
+		testTools.DownloadReader(rr, req, "notes.txt", modTime, strings.NewReader(downloadContent))
+
+		if rr.Code != e.status {
+			t.Errorf("%s: expected status %d, got %d", e.name, e.status, rr.Code)
+		}
+
+		if e.body != "" && rr.Body.String() != e.body {
+			t.Errorf("%s: expected body %q, got %q", e.name, e.body, rr.Body.String())
+		}
+
+		if e.contentType != "" && !strings.HasPrefix(rr.Header().Get("Content-Type"), e.contentType) {
+			t.Errorf("%s: expected content type %s, got %s", e.name, e.contentType, rr.Header().Get("Content-Type"))
+		}
+
+		if rr.Header().Get("ETag") != etag {
+			t.Errorf("%s: expected hash based etag, got %s", e.name, rr.Header().Get("ETag"))
+		}
+	}
+}
+
+func TestTools_DownloadStaticFileETag(t *testing.T) {
+	for name, store := range newTestStorages(t) {
+		body, contentType := newUploadBody(t, "img.png")
+
+		request := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+		request.Header.Add("Content-Type", contentType)
+
+		testTools := Tools{Storage: store}
+
+		uploaded, err := testTools.UploadOneFile(request, "uploads")
+
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		rr := httptest.NewRecorder()
+		testTools.DownloadStaticFile(rr, httptest.NewRequest("GET", "/", nil), "uploads", uploaded.NewFileName, "image.png")
+
+		etag := rr.Header().Get("ETag")
+
+		if etag != `"`+uploaded.SHA256+`"` {
+			t.Errorf("%s: expected etag of the upload hash, got %s", name, etag)
+		}
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("If-None-Match", etag)
+		req.Header.Set("Range", "bytes=0-9")
+
+		rr = httptest.NewRecorder()
+		testTools.DownloadStaticFile(rr, req, "uploads", uploaded.NewFileName, "image.png")
+
+		if rr.Code != http.StatusNotModified {
+			t.Errorf("%s: expected 304 for matching etag, got %d", name, rr.Code)
+		}
+
+		req.Header.Del("If-None-Match")
+
+		rr = httptest.NewRecorder()
+		testTools.DownloadStaticFile(rr, req, "uploads", uploaded.NewFileName, "image.png")
+
+		if rr.Code != http.StatusPartialContent || rr.Body.Len() != 10 {
+			t.Errorf("%s: expected 10 byte partial response, got %d with %d bytes", name, rr.Code, rr.Body.Len())
+		}
+	}
+}
+
+// readCounter counts the bytes read from a ReadSeeker
+type readCounter struct {
+	io.ReadSeeker
+	n int
+}
+
+func (c *readCounter) Read(p []byte) (int, error) {
+	n, err := c.ReadSeeker.Read(p)
+	c.n += n
+
+	return n, err
+}
+
+func TestTools_DownloadReaderWeakETag(t *testing.T) {
+	testTools := Tools{WeakETags: true}
+	modTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	content := strings.Repeat("x", 1<<20)
+
+	counter := &readCounter{ReadSeeker: strings.NewReader(content)}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Range", "bytes=-60")
+
+	rr := httptest.NewRecorder()
+	testTools.DownloadReader(rr, req, "data.bin", modTime, counter)
+
+	if rr.Code != http.StatusPartialContent || rr.Body.Len() != 60 {
+		t.Fatalf("expected 60 byte partial response, got %d with %d bytes", rr.Code, rr.Body.Len())
+	}
+
+	if counter.n > 1024 {
+		t.Errorf("expected a range request not to read the whole content, read %d bytes", counter.n)
+	}
+
+	etag := rr.Header().Get("ETag")
+
+	if !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("expected a weak etag, got %q", etag)
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("If-None-Match", etag)
+
+	rr = httptest.NewRecorder()
+	testTools.DownloadReader(rr, req, "data.bin", modTime, strings.NewReader(content))
+
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for matching weak etag, got %d", rr.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Range", "bytes=0-9")
+	req.Header.Set("If-Range", etag)
+
+	rr = httptest.NewRecorder()
+	testTools.DownloadReader(rr, req, "data.bin", modTime, strings.NewReader(content))
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected a weak etag not to satisfy If-Range, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	testTools.DownloadReader(rr, httptest.NewRequest("GET", "/", nil), "data.bin", modTime, strings.NewReader(content+"y"))
+
+	if rr.Header().Get("ETag") == etag {
+		t.Error("expected the etag to change with the size")
+	}
+}
+
+var contentDispositionTests = []struct {
+	name          string
+	disposition   Disposition
+	fileName      string
+	expected      string
+	errorExpected bool
+}{
+	{name: "plain", fileName: "puppy.jpg", expected: `attachment; filename="puppy.jpg"`},
+	{name: "inline", disposition: DispositionInline, fileName: "report.pdf", expected: `inline; filename="report.pdf"`},
+	{name: "spaces", fileName: "my report.pdf", expected: `attachment; filename="my report.pdf"`},
+	{name: "quotes", fileName: `say "hi".txt`, expected: `attachment; filename="say _hi_.txt"; filename*=UTF-8''say%20%22hi%22.txt`},
+	{name: "backslash", fileName: `a\b.txt`, expected: `attachment; filename="a_b.txt"; filename*=UTF-8''a%5Cb.txt`},
+	{name: "non-ascii", fileName: "résumé €.pdf", expected: `attachment; filename="r_sum_ _.pdf"; filename*=UTF-8''r%C3%A9sum%C3%A9%20%E2%82%AC.pdf`},
+	{name: "header injection", fileName: "a.txt\r\nSet-Cookie: x=1", errorExpected: true},
+	{name: "null byte", fileName: "a\x00.txt", errorExpected: true},
+	{name: "invalid utf-8", fileName: "a\xff.txt", errorExpected: true},
+	{name: "empty", fileName: "", errorExpected: true},
+}
+
+func TestContentDisposition(t *testing.T) {
+	for _, e := range contentDispositionTests {
+		value, err := ContentDisposition(e.disposition, e.fileName)
+
+		if err == nil && e.errorExpected {
+			t.Errorf("%s: error expected but none received", e.name)
+		}
+
+		if err != nil && !e.errorExpected {
+			t.Errorf("%s: unexpected error %s", e.name, err)
+		}
+
+		if value != e.expected {
+			t.Errorf("%s: expected %s, got %s", e.name, e.expected, value)
+		}
+	}
+}
+
+func TestTools_DownloadReaderDisposition(t *testing.T) {
+	testTools := Tools{Disposition: DispositionInline}
+
+	rr := httptest.NewRecorder()
+	testTools.DownloadReader(rr, httptest.NewRequest("GET", "/", nil), "photo.png", time.Now(), strings.NewReader(downloadContent))
+
+	if rr.Header().Get("Content-Disposition") != `inline; filename="photo.png"` {
+		t.Errorf("expected inline disposition, got %s", rr.Header().Get("Content-Disposition"))
+	}
+
+	rr = httptest.NewRecorder()
+	testTools.DownloadReader(rr, httptest.NewRequest("GET", "/", nil), "a.txt\r\nX-Injected: 1", time.Now(), strings.NewReader(downloadContent))
+
+	if rr.Code != http.StatusInternalServerError || rr.Header().Get("Content-Disposition") != "" || strings.Contains(rr.Body.String(), downloadContent) {
+		t.Errorf("expected unsafe name to be refused, got %d with %q", rr.Code, rr.Header().Get("Content-Disposition"))
+	}
+}
+
+var jailTests = []struct {
+	name   string
+	file   string
+	status int
+}{
+	{name: "file in directory", file: "public.txt", status: http.StatusOK},
+	{name: "file in subdirectory", file: "sub/nested.txt", status: http.StatusOK},
+	{name: "symlink inside directory", file: "inside-link.txt", status: http.StatusOK},
+	{name: "parent directory", file: "../secret.txt", status: http.StatusNotFound},
+	{name: "climbing back in", file: "sub/../../files/public.txt", status: http.StatusNotFound},
+	{name: "absolute path", file: "/etc/passwd", status: http.StatusNotFound},
+	{name: "backslashes", file: `..\secret.txt`, status: http.StatusNotFound},
+	{name: "null byte", file: "public.txt\x00.png", status: http.StatusNotFound},
+	{name: "hidden file", file: ".env", status: http.StatusNotFound},
+	{name: "hidden directory", file: ".git/config", status: http.StatusNotFound},
+	{name: "symlink outside directory", file: "outside-link.txt", status: http.StatusNotFound},
+	{name: "directory", file: "sub", status: http.StatusNotFound},
+	{name: "missing file", file: "missing.txt", status: http.StatusNotFound},
+}
+
+func TestTools_DownloadStaticFileJail(t *testing.T) {
+	root := t.TempDir()
+
+	files := map[string]string{
+		"secret.txt":           "secret",
+		"files/public.txt":     "public",
+		"files/sub/nested.txt": "nested",
+		"files/.env":           "secret",
+		"files/.git/config":    "secret",
+	}
+
+	for name, content := range files {
+		fp := filepath.Join(root, filepath.FromSlash(name))
+		_ = os.MkdirAll(filepath.Dir(fp), 0755)
+
+		if err := os.WriteFile(fp, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.Symlink(filepath.Join(root, "secret.txt"), filepath.Join(root, "files", "outside-link.txt")); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+
+	_ = os.Symlink(filepath.Join(root, "files", "public.txt"), filepath.Join(root, "files", "inside-link.txt"))
+
+	testTools := Tools{Storage: LocalStorage{Root: root}}
+
+	for _, e := range jailTests {
+		rr := httptest.NewRecorder()
+
+		testTools.DownloadStaticFile(rr, httptest.NewRequest("GET", "/", nil), "files", e.file, "download.txt")
+
+		if rr.Code != e.status {
+			t.Errorf("%s: expected status %d, got %d", e.name, e.status, rr.Code)
+		}
+
+		if rr.Code == http.StatusNotFound && strings.Contains(rr.Body.String(), "secret") {
+			t.Errorf("%s: response leaked file content", e.name)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package toolkit
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrFileTooLarge is matched by errors.Is for any *FileTooLargeError
+	ErrFileTooLarge = errors.New("file too large")
+	// ErrRequestTooLarge is matched by errors.Is for any *RequestTooLargeError
+	ErrRequestTooLarge = errors.New("request too large")
+	// ErrTooManyFiles is matched by errors.Is for any *TooManyFilesError
+	ErrTooManyFiles = errors.New("too many files")
+	// ErrUnsafeFileName is matched by errors.Is for any *UnsafeFileNameError
+	ErrUnsafeFileName = errors.New("unsafe file name")
+	// ErrFileExists is matched by errors.Is for any *FileExistsError
+	ErrFileExists = errors.New("file already exists")
+	// ErrExtensionMismatch is matched by errors.Is for any *ExtensionMismatchError
+	ErrExtensionMismatch = errors.New("file extension does not match content")
+	// ErrImageTooLarge is matched by errors.Is for any *ImageTooLargeError
+	ErrImageTooLarge = errors.New("image dimensions too large")
+	// ErrInfectedFile is matched by errors.Is for any *InfectedFileError
+	ErrInfectedFile = errors.New("file is infected")
+	// ErrMissingField is matched by errors.Is for any *MissingFieldError
+	ErrMissingField = errors.New("required field is missing")
+	// ErrNoFile is returned by UploadOneFile for requests without a file
+	ErrNoFile = errors.New("request contains no file")
+	// ErrInvalidSignature is returned for signed URLs that do not verify
+	ErrInvalidSignature = errors.New("invalid url signature")
+	// ErrURLExpired is returned for signed URLs past their expiry
+	ErrURLExpired = errors.New("url has expired")
+	// ErrValidation is matched by errors.Is for any *ValidationError
+	ErrValidation = errors.New("validation failed")
+	// ErrMalformedJSON is matched by errors.Is for any *MalformedJSONError
+	ErrMalformedJSON = errors.New("malformed json")
+	// ErrUnknownField is matched by errors.Is for any *UnknownFieldError
+	ErrUnknownField = errors.New("unknown json field")
+	// ErrBodyTooLarge is matched by errors.Is for any *BodyTooLargeError
+	ErrBodyTooLarge = errors.New("body too large")
+)
+
+// FileTooLargeError is returned when a single uploaded file exceeds MaxFileSize
+type FileTooLargeError struct {
+	FileName string
+	Limit    int64
+}
+
+func (e *FileTooLargeError) Error() string {
+	return fmt.Sprintf("file [%s] is larger than %d bytes", e.FileName, e.Limit)
+}
+
+func (e *FileTooLargeError) Is(target error) bool {
+	return target == ErrFileTooLarge
+}
+
+// RequestTooLargeError is returned when the whole request body exceeds MaxRequestSize
+type RequestTooLargeError struct {
+	Limit int64
+}
+
+func (e *RequestTooLargeError) Error() string {
+	return fmt.Sprintf("request body is larger than %d bytes", e.Limit)
+}
+
+func (e *RequestTooLargeError) Is(target error) bool {
+	return target == ErrRequestTooLarge
+}
+
+// TooManyFilesError is returned when a request contains more than
+// MaxFileCount files, or a field more than its FieldRule allows
+type TooManyFilesError struct {
+	Field string
+	Limit int
+}
+
+func (e *TooManyFilesError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("field [%s] contains more than %d files", e.Field, e.Limit)
+	}
+
+	return fmt.Sprintf("request contains more than %d files", e.Limit)
+}
+
+func (e *TooManyFilesError) Is(target error) bool {
+	return target == ErrTooManyFiles
+}
+
+// UnsafeFileNameError is returned for file names that try to escape the
+// upload directory or cannot be sent safely in a header
+type UnsafeFileNameError struct {
+	FileName string
+}
+
+func (e *UnsafeFileNameError) Error() string {
+	return fmt.Sprintf("file name [%s] is not allowed", e.FileName)
+}
+
+func (e *UnsafeFileNameError) Is(target error) bool {
+	return target == ErrUnsafeFileName
+}
+
+// FileExistsError is returned when CollisionError finds an existing file
+type FileExistsError struct {
+	FileName string
+}
+
+func (e *FileExistsError) Error() string {
+	return fmt.Sprintf("file [%s] already exists", e.FileName)
+}
+
+func (e *FileExistsError) Is(target error) bool {
+	return target == ErrFileExists
+}
+
+// ExtensionMismatchError is returned by ExtensionReject when a file's
+// extension does not fit its detected content type
+type ExtensionMismatchError struct {
+	FileName    string
+	Extension   string
+	ContentType string
+}
+
+func (e *ExtensionMismatchError) Error() string {
+	return fmt.Sprintf("file [%s] has extension [%s] but contains [%s]", e.FileName, e.Extension, e.ContentType)
+}
+
+func (e *ExtensionMismatchError) Is(target error) bool {
+	return target == ErrExtensionMismatch
+}
+
+// ImageTooLargeError is returned for images over MaxImageWidth,
+// MaxImageHeight or MaxImagePixels
+type ImageTooLargeError struct {
+	FileName string
+	Width    int
+	Height   int
+}
+
+func (e *ImageTooLargeError) Error() string {
+	return fmt.Sprintf("image [%s] of %dx%d pixels is too large", e.FileName, e.Width, e.Height)
+}
+
+func (e *ImageTooLargeError) Is(target error) bool {
+	return target == ErrImageTooLarge
+}
+
+// InfectedFileError is returned when a Scanner finds malware in an upload.
+// Quarantined is where the file was kept when QuarantineDir is set.
+type InfectedFileError struct {
+	FileName    string
+	Signature   string
+	Quarantined string
+}
+
+func (e *InfectedFileError) Error() string {
+	return fmt.Sprintf("file [%s] is infected with [%s]", e.FileName, e.Signature)
+}
+
+func (e *InfectedFileError) Is(target error) bool {
+	return target == ErrInfectedFile
+}
+
+// MissingFieldError is returned when a Required field receives no files
+type MissingFieldError struct {
+	Field string
+}
+
+func (e *MissingFieldError) Error() string {
+	return fmt.Sprintf("field [%s] is required", e.Field)
+}
+
+func (e *MissingFieldError) Is(target error) bool {
+	return target == ErrMissingField
+}
+
+// FieldError describes one field that failed validation. Rule is the
+// validate tag rule that failed, or "validate" for errors returned by a
+// Validate method.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned by Validate and lists every failing field
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+
+	for i, f := range e.Fields {
+		if f.Field == "" {
+			messages[i] = f.Message
+			continue
+		}
+
+		messages[i] = fmt.Sprintf("field [%s] %s", f.Field, f.Message)
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// MalformedJSONError is returned by ReadJSON for bodies that are not
+// valid JSON. Offset is where decoding failed, or 0 when the body ended
+// too early.
+type MalformedJSONError struct {
+	Offset int64
+}
+
+func (e *MalformedJSONError) Error() string {
+	if e.Offset > 0 {
+		return fmt.Sprintf("body contains malformed JSON at %d", e.Offset)
+	}
+
+	return "body contains malformed JSON"
+}
+
+func (e *MalformedJSONError) Is(target error) bool {
+	return target == ErrMalformedJSON
+}
+
+// UnknownFieldError is returned by ReadJSON for keys the destination has
+// no field for, unless AllowUnknownFields is set
+type UnknownFieldError struct {
+	Field string
+}
+
+func (e *UnknownFieldError) Error() string {
+	return fmt.Sprintf("body contains unknown key %q", e.Field)
+}
+
+func (e *UnknownFieldError) Is(target error) bool {
+	return target == ErrUnknownField
+}
+
+// BodyTooLargeError is returned by ReadJSON for bodies over MaxJsonSize
+type BodyTooLargeError struct {
+	Limit int64
+}
+
+func (e *BodyTooLargeError) Error() string {
+	return fmt.Sprintf("body must not be larger than %d bytes", e.Limit)
+}
+
+func (e *BodyTooLargeError) Is(target error) bool {
+	return target == ErrBodyTooLarge
+}
+
+// errorStatus returns the HTTP status that best describes err
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrFileTooLarge), errors.Is(err, ErrRequestTooLarge),
+		errors.Is(err, ErrTooManyFiles), errors.Is(err, ErrImageTooLarge), errors.Is(err, ErrBodyTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrFileExists):
+		return http.StatusConflict
+	case errors.Is(err, ErrInfectedFile), errors.Is(err, ErrValidation):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, ErrInvalidSignature), errors.Is(err, ErrURLExpired):
+		return http.StatusForbidden
+	default:
+		return http.StatusBadRequest
+	}
+}
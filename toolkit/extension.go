@@ -0,0 +1,127 @@
+package toolkit
+
+import (
+	"fmt"
+	"mime"
+	"slices"
+	"strings"
+)
+
+// ExtensionPolicy decides what UploadFiles does when a file's extension
+// does not match the content type detected from its bytes.
+type ExtensionPolicy int
+
+const (
+	// ExtensionKeep stores the file with the extension the client sent
+	ExtensionKeep ExtensionPolicy = iota
+	// ExtensionReject fails the upload with an *ExtensionMismatchError
+	ExtensionReject
+	// ExtensionRewrite replaces the extension with one for the detected type
+	ExtensionRewrite
+)
+
+// mimeExtensions lists the extensions accepted for each detected content
+// type, canonical extension first. Types missing here fall back to the
+// mime package; application/octet-stream matches any extension.
+var mimeExtensions = map[string][]string{
+	"image/jpeg":                    {".jpg", ".jpeg", ".jpe", ".jfif"},
+	"image/png":                     {".png"},
+	"image/gif":                     {".gif"},
+	"image/webp":                    {".webp"},
+	"image/bmp":                     {".bmp"},
+	"image/x-icon":                  {".ico"},
+	"application/pdf":               {".pdf"},
+	"application/zip":               {".zip"},
+	"application/x-gzip":            {".gz", ".tgz"},
+	"application/x-rar-compressed":  {".rar"},
+	"application/wasm":              {".wasm"},
+	"application/ogg":               {".ogg", ".ogx"},
+	"application/postscript":        {".ps", ".eps"},
+	"application/vnd.ms-fontobject": {".eot"},
+	"audio/mpeg":                    {".mp3"},
+	"audio/wave":                    {".wav"},
+	"audio/aiff":                    {".aif", ".aiff"},
+	"audio/basic":                   {".au", ".snd"},
+	"audio/midi":                    {".mid", ".midi"},
+	"video/mp4":                     {".mp4", ".m4v"},
+	"video/webm":                    {".webm"},
+	"video/avi":                     {".avi"},
+	"font/ttf":                      {".ttf"},
+	"font/otf":                      {".otf"},
+	"font/collection":               {".ttc"},
+	"font/woff":                     {".woff"},
+	"font/woff2":                    {".woff2"},
+	"text/plain":                    {".txt", ".text", ".csv", ".tsv", ".md", ".log", ".json", ".yaml", ".yml"},
+	"text/html":                     {".html", ".htm"},
+	"text/xml":                      {".xml", ".svg"},
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   {".docx"},
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         {".xlsx"},
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": {".pptx"},
+	"application/vnd.oasis.opendocument.text":                                   {".odt"},
+	"application/vnd.oasis.opendocument.spreadsheet":                            {".ods"},
+	"application/vnd.oasis.opendocument.presentation":                           {".odp"},
+	"application/epub+zip":                          {".epub"},
+	"application/java-archive":                      {".jar"},
+	"application/vnd.android.package-archive":       {".apk"},
+	"application/vnd.apache.parquet":                {".parquet"},
+	"application/x-ole-storage":                     {".doc", ".xls", ".ppt", ".msg"},
+	"application/x-7z-compressed":                   {".7z"},
+	"application/x-xz":                              {".xz"},
+	"application/x-bzip2":                           {".bz2"},
+	"application/zstd":                              {".zst"},
+	"application/x-tar":                             {".tar"},
+	"application/rtf":                               {".rtf"},
+	"application/vnd.sqlite3":                       {".sqlite", ".db"},
+	"application/vnd.microsoft.portable-executable": {".exe", ".dll"},
+	"image/heic":                                    {".heic"},
+	"image/heif":                                    {".heif"},
+	"image/avif":                                    {".avif"},
+	"image/tiff":                                    {".tif", ".tiff"},
+	"image/vnd.adobe.photoshop":                     {".psd"},
+	"video/quicktime":                               {".mov"},
+	"video/3gpp":                                    {".3gp"},
+	"video/x-matroska":                              {".mkv"},
+	"audio/mp4":                                     {".m4a"},
+	"audio/flac":                                    {".flac"},
+}
+
+// extensionsFor returns the extensions accepted for contentType, or nil
+// when the type is unknown.
+func extensionsFor(contentType string) []string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+
+	if err != nil {
+		mediaType = contentType
+	}
+
+	if exts, ok := mimeExtensions[mediaType]; ok {
+		return exts
+	}
+
+	exts, _ := mime.ExtensionsByType(mediaType)
+
+	return exts
+}
+
+// checkExtension applies ExtensionPolicy and AllowedExtensions to ext,
+// returning the extension the file should be stored with.
+func (t *Tools) checkExtension(fileName, ext, contentType string) (string, error) {
+	known := extensionsFor(contentType)
+
+	if len(known) > 0 && !strings.HasPrefix(contentType, "application/octet-stream") && !slices.Contains(known, ext) {
+		switch t.ExtensionMismatch {
+		case ExtensionReject:
+			return "", &ExtensionMismatchError{FileName: fileName, Extension: ext, ContentType: contentType}
+		case ExtensionRewrite:
+			ext = known[0]
+		}
+	}
+
+	if len(t.AllowedExtensions) > 0 && !slices.ContainsFunc(t.AllowedExtensions, func(allowed string) bool {
+		return sanitizeExt("."+strings.TrimPrefix(allowed, ".")) == ext
+	}) {
+		return "", fmt.Errorf("file extension [%s] is not allowed", ext)
+	}
+
+	return ext, nil
+}
@@ -0,0 +1,77 @@
+package toolkit
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"path"
+	"testing"
+)
+
+var extensionTests = []struct {
+	name              string
+	fileName          string
+	policy            ExtensionPolicy
+	allowedExtensions []string
+	rename            bool
+	expectedExt       string
+	errorExpected     bool
+}{
+	{name: "keep mismatch", fileName: "evil.html", policy: ExtensionKeep, expectedExt: ".html"},
+	{name: "reject mismatch", fileName: "evil.html", policy: ExtensionReject, errorExpected: true},
+	{name: "rewrite mismatch", fileName: "evil.html", policy: ExtensionRewrite, expectedExt: ".png"},
+	{name: "rewrite renamed file", fileName: "evil.html", policy: ExtensionRewrite, rename: true, expectedExt: ".png"},
+	{name: "rewrite missing extension", fileName: "evil", policy: ExtensionRewrite, expectedExt: ".png"},
+	{name: "reject matching", fileName: "good.PNG", policy: ExtensionReject, expectedExt: ".png"},
+	{name: "extension not allowed", fileName: "evil.html", allowedExtensions: []string{".png"}, errorExpected: true},
+	{name: "extension allowed without dot", fileName: "good.png", allowedExtensions: []string{"png"}, expectedExt: ".png"},
+	{name: "rewritten extension allowed", fileName: "evil.html", policy: ExtensionRewrite, allowedExtensions: []string{".png"}, expectedExt: ".png"},
+}
+
+func TestTools_UploadFilesExtensions(t *testing.T) {
+	for _, e := range extensionTests {
+		body, contentType := newUploadBody(t, e.fileName)
+
+		request := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+		request.Header.Add("Content-Type", contentType)
+
+		testTools := Tools{
+			Storage:           &MemoryStorage{},
+			ExtensionMismatch: e.policy,
+			AllowedExtensions: e.allowedExtensions,
+		}
+
+		uploadedFile, err := testTools.UploadOneFile(request, "uploads", e.rename)
+
+		if e.errorExpected {
+			if err == nil {
+				t.Errorf("%s: error expected", e.name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", e.name, err)
+			continue
+		}
+
+		if ext := path.Ext(uploadedFile.NewFileName); ext != e.expectedExt {
+			t.Errorf("%s: expected extension %s got %s", e.name, e.expectedExt, ext)
+		}
+	}
+
+	body, contentType := newUploadBody(t, "evil.html")
+
+	request := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+	request.Header.Add("Content-Type", contentType)
+
+	testTools := Tools{Storage: &MemoryStorage{}, ExtensionMismatch: ExtensionReject}
+
+	var mismatch *ExtensionMismatchError
+
+	_, err := testTools.UploadOneFile(request, "uploads")
+
+	if !errors.As(err, &mismatch) || mismatch.Extension != ".html" || mismatch.ContentType != "image/png" {
+		t.Errorf("expected ExtensionMismatchError for .html containing image/png, got %v", err)
+	}
+}
@@ -0,0 +1,211 @@
+package toolkit
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"mime/multipart"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// maxFileNameLength keeps sanitized names within common filesystem limits
+const maxFileNameLength = 200
+
+// CollisionPolicy decides what UploadFiles does when a file kept under
+// its own name (rename false) would replace an existing file.
+//
+// Storages implementing ExclusiveRenamer, such as LocalStorage and
+// MemoryStorage, never let two concurrent uploads take the same name.
+// Others, S3Storage among them, check the name just before moving the
+// file there, so an upload of the same name landing in between can still
+// be overwritten.
+type CollisionPolicy int
+
+const (
+	// CollisionOverwrite replaces the existing file
+	CollisionOverwrite CollisionPolicy = iota
+	// CollisionError fails the upload with a *FileExistsError
+	CollisionError
+	// CollisionSuffix stores the file as name-1.ext, name-2.ext and so on
+	CollisionSuffix
+)
+
+// maxCollisionSuffix bounds how many suffixed names CollisionSuffix tries
+const maxCollisionSuffix = 1000
+
+var reservedFileNames = regexp.MustCompile(`^(con|prn|aux|nul|com\d|lpt\d)$`)
+
+var unsafeExtension = regexp.MustCompile(`[^a-z\d]+`)
+
+// SanitizeFileName turns a client supplied file name into one that is
+// safe to store. Names with ".." path segments are rejected, any other
+// directories are dropped, the stem is slugified and the extension is
+// lower-cased with anything but letters and digits removed.
+func (t *Tools) SanitizeFileName(name string) (string, error) {
+	name = strings.ReplaceAll(name, `\`, "/")
+
+	for _, segment := range strings.Split(name, "/") {
+		if segment == ".." {
+			return "", &UnsafeFileNameError{FileName: name}
+		}
+	}
+
+	base := path.Base(name)
+	ext := sanitizeExt(path.Ext(base))
+
+	stem, err := t.Slugify(strings.TrimSuffix(base, path.Ext(base)))
+
+	if err != nil {
+		stem = "file"
+	}
+
+	if reservedFileNames.MatchString(stem) {
+		stem += "-file"
+	}
+
+	if len(stem)+len(ext) > maxFileNameLength {
+		stem = strings.TrimRight(stem[:maxFileNameLength-len(ext)], "-")
+	}
+
+	return stem + ext, nil
+}
+
+// sanitizeExt lower-cases ext and strips anything but letters and digits
+func sanitizeExt(ext string) string {
+	ext = unsafeExtension.ReplaceAllLiteralString(strings.ToLower(ext), "")
+
+	if ext == "" {
+		return ""
+	}
+
+	return "." + ext
+}
+
+// rawFileName returns the file name exactly as the client sent it;
+// multipart.Part.FileName strips directories, hiding traversal attempts.
+func rawFileName(part *multipart.Part) string {
+	_, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+
+	if err != nil || params["filename"] == "" {
+		return part.FileName()
+	}
+
+	return params["filename"]
+}
+
+// isFilePart reports whether part came from a file input, even one
+// submitted without a file
+func isFilePart(part *multipart.Part) bool {
+	_, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+
+	if err != nil {
+		return false
+	}
+
+	_, ok := params["filename"]
+
+	return ok
+}
+
+// resolveCollision applies FileCollision to uploadDir/name and returns
+// the name the file should be stored under.
+func (t *Tools) resolveCollision(uploadDir, name string) (string, error) {
+	store := t.storage()
+
+	if t.FileCollision == CollisionOverwrite {
+		return name, nil
+	}
+
+	if exists, err := storedFileExists(store, path.Join(uploadDir, name)); err != nil || !exists {
+		return name, err
+	}
+
+	if t.FileCollision == CollisionError {
+		return "", &FileExistsError{FileName: name}
+	}
+
+	ext := path.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+
+	for i := 1; i <= maxCollisionSuffix; i++ {
+		candidate := fmt.Sprintf("%s-%d%s", stem, i, ext)
+
+		if exists, err := storedFileExists(store, path.Join(uploadDir, candidate)); err != nil || !exists {
+			return candidate, err
+		}
+	}
+
+	return "", &FileExistsError{FileName: name}
+}
+
+// commitUpload moves the stored file tmp to uploadDir/name, or under
+// CollisionSuffix to the first free suffixed name. Storages implementing
+// ExclusiveRenamer never replace a file stored in the meantime; others
+// are checked again just before the move. tmp is deleted on failure.
+func (t *Tools) commitUpload(tmp, uploadDir, name string) (string, error) {
+	store := t.storage()
+
+	renamer, ok := store.(ExclusiveRenamer)
+
+	if !ok {
+		final, err := t.resolveCollision(uploadDir, name)
+
+		if err == nil {
+			err = moveFile(store, tmp, path.Join(uploadDir, final))
+		}
+
+		if err != nil {
+			_ = store.Delete(tmp)
+			return "", err
+		}
+
+		return final, nil
+	}
+
+	ext := path.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+
+	for i := 0; i <= maxCollisionSuffix; i++ {
+		candidate := name
+		if i > 0 {
+			candidate = fmt.Sprintf("%s-%d%s", stem, i, ext)
+		}
+
+		err := renamer.RenameExclusive(tmp, path.Join(uploadDir, candidate))
+
+		if err == nil {
+			return candidate, nil
+		}
+
+		if !errors.Is(err, fs.ErrExist) {
+			_ = store.Delete(tmp)
+			return "", err
+		}
+
+		if t.FileCollision == CollisionError {
+			break
+		}
+	}
+
+	_ = store.Delete(tmp)
+
+	return "", &FileExistsError{FileName: name}
+}
+
+// storedFileExists reports whether name is stored, passing on any error
+// other than the file not existing
+func storedFileExists(store Storage, name string) (bool, error) {
+	_, err := store.Stat(name)
+
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, fs.ErrNotExist):
+		return false, nil
+	default:
+		return false, err
+	}
+}
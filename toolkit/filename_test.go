@@ -0,0 +1,208 @@
+package toolkit
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var sanitizeTests = []struct {
+	name          string
+	fileName      string
+	expected      string
+	errorExpected bool
+}{
+	{name: "plain", fileName: "photo.png", expected: "photo.png"},
+	{name: "spaces and case", fileName: "My Holiday Photo.JPG", expected: "my-holiday-photo.jpg"},
+	{name: "directories dropped", fileName: "./testdata/img.png", expected: "img.png"},
+	{name: "traversal", fileName: "../../etc/x", errorExpected: true},
+	{name: "windows traversal", fileName: `..\..\windows\x.dll`, errorExpected: true},
+	{name: "control characters", fileName: "bad\x00na\nme.txt", expected: "bad-na-me.txt"},
+	{name: "reserved device name", fileName: "CON.txt", expected: "con-file.txt"},
+	{name: "unsafe extension", fileName: "x.ph p%00", expected: "x.php00"},
+	{name: "no usable stem", fileName: "こんにちは.png", expected: "file.png"},
+	{name: "too long", fileName: strings.Repeat("a", 300) + ".png", expected: strings.Repeat("a", maxFileNameLength-4) + ".png"},
+}
+
+func TestTools_SanitizeFileName(t *testing.T) {
+	var testTools Tools
+
+	for _, e := range sanitizeTests {
+		name, err := testTools.SanitizeFileName(e.fileName)
+
+		if e.errorExpected {
+			if !errors.Is(err, ErrUnsafeFileName) {
+				t.Errorf("%s: expected ErrUnsafeFileName, got %v", e.name, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", e.name, err)
+		}
+
+		if name != e.expected {
+			t.Errorf("%s: expected %s got %s", e.name, e.expected, name)
+		}
+	}
+}
+
+var collisionTests = []struct {
+	name     string
+	policy   CollisionPolicy
+	expected []string
+	err      error
+}{
+	{name: "overwrite", policy: CollisionOverwrite, expected: []string{"img.png", "img.png"}},
+	{name: "error", policy: CollisionError, expected: []string{"img.png"}, err: ErrFileExists},
+	{name: "suffix", policy: CollisionSuffix, expected: []string{"img.png", "img-1.png"}},
+}
+
+func TestTools_UploadFilesCollision(t *testing.T) {
+	body, contentType := newUploadBody(t, "img.png", "img.png")
+
+	for _, e := range collisionTests {
+		request := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+		request.Header.Add("Content-Type", contentType)
+
+		testTools := Tools{Storage: &MemoryStorage{}, FileCollision: e.policy}
+
+		uploadedFiles, err := testTools.UploadFiles(request, "uploads", false)
+
+		if !errors.Is(err, e.err) {
+			t.Errorf("%s: expected %v got %v", e.name, e.err, err)
+		}
+
+		if len(uploadedFiles) != len(e.expected) {
+			t.Fatalf("%s: expected %d files got %d", e.name, len(e.expected), len(uploadedFiles))
+		}
+
+		for i, f := range uploadedFiles {
+			if f.NewFileName != e.expected[i] {
+				t.Errorf("%s: expected %s got %s", e.name, e.expected[i], f.NewFileName)
+			}
+		}
+	}
+}
+
+// failingStatStorage fails every Stat with an error other than not found
+type failingStatStorage struct {
+	*MemoryStorage
+}
+
+func (s failingStatStorage) Stat(name string) (*StoredFile, error) {
+	return nil, errors.New("access denied")
+}
+
+func TestTools_UploadFilesCollisionStatError(t *testing.T) {
+	body, contentType := newUploadBody(t, "img.png")
+
+	request := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+	request.Header.Add("Content-Type", contentType)
+
+	store := failingStatStorage{&MemoryStorage{}}
+	testTools := Tools{Storage: store, FileCollision: CollisionError}
+
+	_, err := testTools.UploadFiles(request, "uploads", false)
+
+	if err == nil || err.Error() != "access denied" {
+		t.Errorf("expected the stat error, got %v", err)
+	}
+
+	if files, _ := store.List(""); len(files) != 0 {
+		t.Errorf("expected nothing stored, found %d files", len(files))
+	}
+}
+
+var commitUploadTests = []struct {
+	name     string
+	policy   CollisionPolicy
+	expected string
+	err      error
+}{
+	{name: "error", policy: CollisionError, err: ErrFileExists},
+	{name: "suffix", policy: CollisionSuffix, expected: "img-1.png"},
+}
+
+func TestTools_CommitUploadNoClobber(t *testing.T) {
+	for _, e := range commitUploadTests {
+		for storeName, store := range map[string]Storage{"local": LocalStorage{Root: t.TempDir()}, "memory": &MemoryStorage{}} {
+			testTools := Tools{Storage: store, FileCollision: e.policy}
+
+			// the file arrives after the name was checked, as from a concurrent upload
+			_, _ = store.Put("uploads/img.png", strings.NewReader("first"))
+			_, _ = store.Put("uploads/.upload-tmp.png", strings.NewReader("second"))
+
+			name, err := testTools.commitUpload("uploads/.upload-tmp.png", "uploads", "img.png")
+
+			if !errors.Is(err, e.err) || name != e.expected {
+				t.Errorf("%s %s: expected %q and %v, got %q and %v", e.name, storeName, e.expected, e.err, name, err)
+			}
+
+			f, _ := store.Get("uploads/img.png")
+			content, _ := io.ReadAll(f)
+			f.Close()
+
+			if string(content) != "first" {
+				t.Errorf("%s %s: existing file was overwritten with %q", e.name, storeName, content)
+			}
+
+			if _, err = store.Stat("uploads/.upload-tmp.png"); err == nil {
+				t.Errorf("%s %s: expected the temporary file to be gone", e.name, storeName)
+			}
+		}
+	}
+}
+
+func TestTools_UploadFilesConcurrentSameName(t *testing.T) {
+	body, contentType := newUploadBody(t, "img.png")
+	testTools := Tools{Storage: LocalStorage{Root: t.TempDir()}, FileCollision: CollisionSuffix}
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			request := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+			request.Header.Add("Content-Type", contentType)
+
+			if _, err := testTools.UploadFiles(request, "uploads", false); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if files, _ := testTools.Storage.List("uploads/"); len(files) != 8 {
+		t.Errorf("expected 8 distinct files, found %d", len(files))
+	}
+}
+
+func TestTools_UploadFilesRejectsTraversal(t *testing.T) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	part, _ := writer.CreateFormFile("file", "../../etc/x.png")
+	_, _ = part.Write([]byte("\x89PNG\r\n\x1a\n"))
+	writer.Close()
+
+	request := httptest.NewRequest("POST", "/", &body)
+	request.Header.Add("Content-Type", writer.FormDataContentType())
+
+	testTools := Tools{Storage: &MemoryStorage{}}
+
+	_, err := testTools.UploadFiles(request, "uploads", false)
+
+	if !errors.Is(err, ErrUnsafeFileName) {
+		t.Errorf("expected ErrUnsafeFileName, got %v", err)
+	}
+}
@@ -0,0 +1,282 @@
+package toolkit
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// maxFormValuesSize caps the combined size of the text fields kept by UploadForm
+const maxFormValuesSize = 10 * 1024 * 1024
+
+// FieldRule constrains the files sent in one form field. MaxFileSize and
+// AllowedFileTypes replace the Tools settings for that field when set.
+type FieldRule struct {
+	Required         bool
+	MaxCount         int
+	MaxFileSize      int
+	AllowedFileTypes []string
+}
+
+// UploadResult holds everything UploadForm read from a request. Files are
+// in the order they arrived and Fields groups the same files by the form
+// field they were sent in. Values holds the text fields.
+type UploadResult struct {
+	Files  []*UploadedFile
+	Fields map[string][]*UploadedFile
+	Values url.Values
+
+	audit []AuditEvent
+}
+
+// File returns the first file sent in field, or nil
+func (u *UploadResult) File(field string) *UploadedFile {
+	if files := u.Fields[field]; len(files) > 0 {
+		return files[0]
+	}
+
+	return nil
+}
+
+// Value returns the first text value of field
+func (u *UploadResult) Value(field string) string {
+	return u.Values.Get(field)
+}
+
+// UploadForm uploads the files of a multipart request like UploadFiles,
+// but also keeps the text fields and records which field each file was
+// sent in. Files in a field with a FieldRules entry must satisfy it: a
+// Required field without files returns a *MissingFieldError, and more
+// than MaxCount files a *TooManyFilesError naming the field.
+func (t *Tools) UploadForm(r *http.Request, uploadDir string, rename ...bool) (*UploadResult, error) {
+	renameFile := true
+	if len(rename) > 0 {
+		renameFile = rename[0]
+	}
+
+	return t.uploadForm(r, uploadDir, renameFile, nil)
+}
+
+// fileFilter decides, before anything is stored, whether the files sent
+// in field are kept; false skips them and an error ends the upload
+type fileFilter func(field string) (bool, error)
+
+// uploadForm is UploadForm, only storing files in fields accepted by
+// filter when it is not nil
+func (t *Tools) uploadForm(r *http.Request, uploadDir string, renameFile bool, filter fileFilter) (*UploadResult, error) {
+	start := time.Now()
+
+	if t.MaxRequestSize > 0 {
+		if r.ContentLength > t.MaxRequestSize {
+			err := &RequestTooLargeError{Limit: t.MaxRequestSize}
+			t.auditUploads(r, nil, err, start)
+			return nil, err
+		}
+
+		r.Body = http.MaxBytesReader(nil, r.Body, t.MaxRequestSize)
+	}
+
+	progress := t.newProgressReader(r)
+
+	reader, err := r.MultipartReader()
+
+	if err != nil {
+		t.auditUploads(r, nil, err, start)
+		return nil, err
+	}
+
+	result := &UploadResult{Fields: make(map[string][]*UploadedFile), Values: make(url.Values)}
+
+	err = t.uploadParts(r, reader, uploadDir, renameFile, filter, progress, result)
+
+	if err == nil {
+		err = t.checkRequiredFields(result)
+	}
+
+	progress.finish(err)
+	t.auditUploads(r, result, err, start)
+
+	if err != nil && t.AllOrNothing {
+		t.removeUploads(uploadDir, result.Files)
+		return nil, err
+	}
+
+	return result, err
+}
+
+// uploadParts uploads each file part in the order it arrives and keeps
+// the text fields
+func (t *Tools) uploadParts(r *http.Request, reader *multipart.Reader, uploadDir string, renameFile bool, filter fileFilter, progress *progressReader, result *UploadResult) error {
+	valuesSize := 0
+
+	for {
+		part, err := reader.NextPart()
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return t.uploadError(err)
+		}
+
+		field := part.FormName()
+
+		if part.FileName() == "" {
+			err = readFormValue(part, result.Values, &valuesSize)
+			part.Close()
+
+			if err != nil {
+				return t.uploadError(err)
+			}
+
+			continue
+		}
+
+		if filter != nil {
+			keep, err := filter(field)
+
+			if err != nil || !keep {
+				part.Close()
+
+				if err != nil {
+					return err
+				}
+
+				continue
+			}
+		}
+
+		if t.MaxFileCount > 0 && len(result.Files) >= t.MaxFileCount {
+			part.Close()
+			return &TooManyFilesError{Limit: t.MaxFileCount}
+		}
+
+		rule := t.FieldRules[field]
+
+		if rule.MaxCount > 0 && len(result.Fields[field]) >= rule.MaxCount {
+			part.Close()
+			return &TooManyFilesError{Field: field, Limit: rule.MaxCount}
+		}
+
+		progress.setFile(part.FileName())
+
+		partStart := time.Now()
+		counter := &countingReader{r: part}
+
+		uploadedFile, err := t.forField(rule).uploadPart(counter, rawFileName(part), uploadDir, renameFile)
+		part.Close()
+
+		if err != nil {
+			err = t.uploadError(err)
+		}
+
+		if t.OnAudit != nil {
+			result.audit = append(result.audit, uploadEvent(r, uploadDir, part.FileName(), uploadedFile, counter.n, err, partStart))
+		}
+
+		if err != nil {
+			return err
+		}
+
+		uploadedFile.FieldName = field
+
+		result.Files = append(result.Files, uploadedFile)
+		result.Fields[field] = append(result.Fields[field], uploadedFile)
+	}
+}
+
+// forField returns the Tools to upload a file in a field governed by rule
+func (t *Tools) forField(rule FieldRule) *Tools {
+	if len(rule.AllowedFileTypes) == 0 && rule.MaxFileSize == 0 {
+		return t
+	}
+
+	fieldTools := *t
+
+	if len(rule.AllowedFileTypes) > 0 {
+		fieldTools.AllowedFileTypes = rule.AllowedFileTypes
+	}
+
+	if rule.MaxFileSize > 0 {
+		fieldTools.MaxFileSize = rule.MaxFileSize
+	}
+
+	return &fieldTools
+}
+
+// readFormValue adds a text field to values, keeping the combined size
+// of all values under maxFormValuesSize. Empty file inputs are skipped.
+func readFormValue(part *multipart.Part, values url.Values, size *int) error {
+	value, err := io.ReadAll(io.LimitReader(part, int64(maxFormValuesSize-*size+1)))
+
+	if err != nil {
+		return err
+	}
+
+	*size += len(value)
+
+	if *size > maxFormValuesSize {
+		return fmt.Errorf("form values are larger than %d bytes", maxFormValuesSize)
+	}
+
+	if isFilePart(part) {
+		return nil
+	}
+
+	values.Add(part.FormName(), string(value))
+
+	return nil
+}
+
+// checkRequiredFields reports the first Required field, by name, that
+// received no files
+func (t *Tools) checkRequiredFields(result *UploadResult) error {
+	var fields []string
+
+	for field, rule := range t.FieldRules {
+		if rule.Required && len(result.Fields[field]) == 0 {
+			fields = append(fields, field)
+		}
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+
+	sort.Strings(fields)
+
+	return &MissingFieldError{Field: fields[0]}
+}
+
+// auditUploads reports the files of an upload to OnAudit. Files removed
+// again because of AllOrNothing are reported as failed, and errors not
+// caused by a file get an event of their own.
+func (t *Tools) auditUploads(r *http.Request, result *UploadResult, err error, start time.Time) {
+	if t.OnAudit == nil {
+		return
+	}
+
+	var events []AuditEvent
+	if result != nil {
+		events = result.audit
+	}
+
+	for _, event := range events {
+		if err != nil && t.AllOrNothing && event.Completed {
+			event.Completed = false
+			event.Status = errorStatus(err)
+			event.Error = err.Error()
+		}
+
+		t.OnAudit(event)
+	}
+
+	if err != nil && (len(events) == 0 || events[len(events)-1].Error == "") {
+		t.OnAudit(uploadEvent(r, "", "", nil, 0, err, start))
+	}
+}
@@ -0,0 +1,184 @@
+package toolkit
+
+import (
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// formPart is a text field when fileName is empty, otherwise a file
+type formPart struct {
+	field    string
+	fileName string
+	value    string
+}
+
+func newForm(t *testing.T, parts ...formPart) ([]byte, string) {
+	img, err := os.ReadFile("./testdata/img.png")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	for _, p := range parts {
+		if p.fileName == "" {
+			_ = writer.WriteField(p.field, p.value)
+			continue
+		}
+
+		part, err := writer.CreateFormFile(p.field, p.fileName)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if p.value != "" {
+			_, _ = part.Write([]byte(p.value))
+		} else {
+			_, _ = part.Write(img)
+		}
+	}
+
+	writer.Close()
+
+	return body.Bytes(), writer.FormDataContentType()
+}
+
+func TestTools_UploadForm(t *testing.T) {
+	body, contentType := newForm(t,
+		formPart{field: "title", value: "holiday"},
+		formPart{field: "banner", fileName: "b1.png"},
+		formPart{field: "avatar", fileName: "a.png"},
+		formPart{field: "banner", fileName: "b2.png"},
+		formPart{field: "tag", value: "sea"},
+		formPart{field: "tag", value: "sun"},
+	)
+
+	request := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+	request.Header.Add("Content-Type", contentType)
+
+	testTools := Tools{Storage: &MemoryStorage{}}
+
+	result, err := testTools.UploadForm(request, "uploads", false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var order []string
+	for _, f := range result.Files {
+		order = append(order, f.FieldName+"/"+f.OriginalFileName)
+	}
+
+	if len(order) != 3 || order[0] != "banner/b1.png" || order[1] != "avatar/a.png" || order[2] != "banner/b2.png" {
+		t.Errorf("expected files in the order they were sent, got %v", order)
+	}
+
+	if result.File("avatar") == nil || result.File("avatar").OriginalFileName != "a.png" {
+		t.Errorf("expected avatar file, got %v", result.File("avatar"))
+	}
+
+	if len(result.Fields["banner"]) != 2 || result.File("missing") != nil {
+		t.Errorf("unexpected grouping %v", result.Fields)
+	}
+
+	if result.Value("title") != "holiday" || len(result.Values["tag"]) != 2 || result.Values["tag"][1] != "sun" {
+		t.Errorf("unexpected form values %v", result.Values)
+	}
+}
+
+var fieldRuleTests = []struct {
+	name          string
+	parts         []formPart
+	rules         map[string]FieldRule
+	errorExpected bool
+	err           error
+}{
+	{
+		name:  "within rules",
+		parts: []formPart{{field: "avatar", fileName: "a.png"}},
+		rules: map[string]FieldRule{"avatar": {Required: true, MaxCount: 1, AllowedFileTypes: []string{"image/png"}}},
+	},
+	{
+		name:          "missing required field",
+		parts:         []formPart{{field: "title", value: "no files"}, {field: "banner", fileName: "b.png"}},
+		rules:         map[string]FieldRule{"avatar": {Required: true}},
+		errorExpected: true,
+		err:           ErrMissingField,
+	},
+	{
+		name:          "too many files in field",
+		parts:         []formPart{{field: "avatar", fileName: "a.png"}, {field: "avatar", fileName: "b.png"}},
+		rules:         map[string]FieldRule{"avatar": {MaxCount: 1}},
+		errorExpected: true,
+		err:           ErrTooManyFiles,
+	},
+	{
+		name:          "type not allowed in field",
+		parts:         []formPart{{field: "banner", fileName: "b.png"}, {field: "avatar", fileName: "a.png"}},
+		rules:         map[string]FieldRule{"avatar": {AllowedFileTypes: []string{"image/jpeg"}}},
+		errorExpected: true,
+	},
+}
+
+func TestTools_UploadFormFieldRules(t *testing.T) {
+	for _, e := range fieldRuleTests {
+		body, contentType := newForm(t, e.parts...)
+
+		request := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+		request.Header.Add("Content-Type", contentType)
+
+		store := &MemoryStorage{}
+		testTools := Tools{Storage: store, FieldRules: e.rules, AllOrNothing: true}
+
+		_, err := testTools.UploadForm(request, "uploads")
+
+		if err == nil && e.errorExpected {
+			t.Errorf("%s: error expected but none received", e.name)
+		}
+
+		if err != nil && !e.errorExpected {
+			t.Errorf("%s: unexpected error %s", e.name, err)
+		}
+
+		if e.err != nil && !errors.Is(err, e.err) {
+			t.Errorf("%s: expected %v, got %v", e.name, e.err, err)
+		}
+
+		var tooMany *TooManyFilesError
+
+		if errors.As(err, &tooMany) && tooMany.Field != "avatar" {
+			t.Errorf("%s: expected error to name the avatar field, got %s", e.name, err)
+		}
+
+		if files, _ := store.List(""); err != nil && len(files) != 0 {
+			t.Errorf("%s: expected rejected form to leave nothing behind, got %d files", e.name, len(files))
+		}
+	}
+}
+
+func TestTools_UploadFormSkipsEmptyFileInput(t *testing.T) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	_, _ = writer.CreateFormFile("avatar", "")
+	_ = writer.WriteField("title", "no file chosen")
+	writer.Close()
+
+	request := httptest.NewRequest("POST", "/", &body)
+	request.Header.Add("Content-Type", writer.FormDataContentType())
+
+	testTools := Tools{Storage: &MemoryStorage{}}
+
+	result, err := testTools.UploadForm(request, "uploads")
+
+	if err != nil || len(result.Files) != 0 || result.Values.Has("avatar") || result.Value("title") != "no file chosen" {
+		t.Errorf("unexpected result %+v, %v", result, err)
+	}
+}
@@ -0,0 +1,394 @@
+package toolkit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path"
+	"strings"
+)
+
+// maxImageHeaderLen bounds how much of an upload is read to find its dimensions
+const maxImageHeaderLen = 1024 * 1024
+
+// defaultMaxThumbnailPixels guards thumbnail decoding when MaxImagePixels is not set
+const defaultMaxThumbnailPixels = 64 * 1024 * 1024
+
+// ThumbnailSize describes a thumbnail variant generated for uploaded images.
+// The image is scaled down to fit within Width x Height, keeping its
+// aspect ratio; a zero Width or Height leaves that side unconstrained.
+type ThumbnailSize struct {
+	Name   string
+	Width  int
+	Height int
+}
+
+// Thumbnail is a generated variant recorded on UploadedFile
+type Thumbnail struct {
+	Name        string
+	NewFileName string
+	Width       int
+	Height      int
+}
+
+// decodableImage reports whether the standard library can decode contentType
+func decodableImage(contentType string) bool {
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	}
+
+	return false
+}
+
+// processesImages reports whether any image option is configured
+func (t *Tools) processesImages() bool {
+	return t.MaxImageWidth > 0 || t.MaxImageHeight > 0 || t.MaxImagePixels > 0 ||
+		t.StripImageMetadata || len(t.Thumbnails) > 0
+}
+
+// checkImageSize reads the image header from src and rejects images over
+// MaxImageWidth, MaxImageHeight or MaxImagePixels before the pixels are
+// decoded. The returned reader replays the header bytes it consumed.
+func (t *Tools) checkImageSize(src io.Reader, fileName string) (io.Reader, error) {
+	var header bytes.Buffer
+
+	config, _, err := image.DecodeConfig(io.TeeReader(io.LimitReader(src, maxImageHeaderLen), &header))
+
+	if err != nil {
+		return nil, fmt.Errorf("file [%s] is not a readable image: %w", fileName, err)
+	}
+
+	maxPixels := t.MaxImagePixels
+	if maxPixels == 0 && len(t.Thumbnails) > 0 {
+		maxPixels = defaultMaxThumbnailPixels
+	}
+
+	if (t.MaxImageWidth > 0 && config.Width > t.MaxImageWidth) ||
+		(t.MaxImageHeight > 0 && config.Height > t.MaxImageHeight) ||
+		(maxPixels > 0 && config.Width*config.Height > maxPixels) {
+		return nil, &ImageTooLargeError{FileName: fileName, Width: config.Width, Height: config.Height}
+	}
+
+	return io.MultiReader(&header, src), nil
+}
+
+// jpegStripper copies a JPEG stream while dropping the segments that
+// carry EXIF, GPS, XMP, IPTC and comments. Segments needed to render
+// the image, such as ICC profiles and Adobe colour transforms, are kept,
+// and so is the EXIF Orientation tag.
+type jpegStripper struct {
+	src     *bufio.Reader
+	out     bytes.Buffer
+	started bool
+	body    bool
+	err     error
+}
+
+func stripJPEGMetadata(src io.Reader) io.Reader {
+	return &jpegStripper{src: bufio.NewReader(src)}
+}
+
+func (j *jpegStripper) Read(p []byte) (int, error) {
+	for j.out.Len() == 0 && !j.body && j.err == nil {
+		j.err = j.next()
+	}
+
+	if j.out.Len() > 0 {
+		return j.out.Read(p)
+	}
+
+	if j.err != nil {
+		return 0, j.err
+	}
+
+	return j.src.Read(p)
+}
+
+// next copies or skips one marker segment
+func (j *jpegStripper) next() error {
+	if !j.started {
+		j.started = true
+
+		soi := make([]byte, 2)
+		if _, err := io.ReadFull(j.src, soi); err != nil || soi[0] != 0xFF || soi[1] != 0xD8 {
+			return errors.New("invalid JPEG: missing start of image")
+		}
+
+		j.out.Write(soi)
+
+		return nil
+	}
+
+	marker, err := j.src.ReadByte()
+
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+
+	if marker != 0xFF {
+		return errors.New("invalid JPEG: expected marker")
+	}
+
+	// markers may be padded with any number of 0xFF fill bytes
+	for marker == 0xFF {
+		if marker, err = j.src.ReadByte(); err != nil {
+			return unexpectedEOF(err)
+		}
+	}
+
+	// start of scan: the entropy coded data follows, pass the rest through
+	if marker == 0xDA || marker == 0xD9 {
+		j.out.Write([]byte{0xFF, marker})
+		j.body = true
+		return nil
+	}
+
+	// standalone markers carry no length
+	if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+		j.out.Write([]byte{0xFF, marker})
+		return nil
+	}
+
+	length := make([]byte, 2)
+	if _, err = io.ReadFull(j.src, length); err != nil {
+		return unexpectedEOF(err)
+	}
+
+	n := int(binary.BigEndian.Uint16(length)) - 2
+
+	if n < 0 {
+		return errors.New("invalid JPEG: bad segment length")
+	}
+
+	// APP1 (EXIF, XMP) is replaced by a minimal EXIF segment holding only
+	// the orientation, so rotated photos still display upright
+	if marker == 0xE1 {
+		segment := make([]byte, n)
+		if _, err = io.ReadFull(j.src, segment); err != nil {
+			return unexpectedEOF(err)
+		}
+
+		if order, orientation, ok := exifOrientation(segment); ok && orientation != 1 {
+			j.out.Write(orientationSegment(order, orientation))
+		}
+
+		return nil
+	}
+
+	// APP13 (IPTC) and COM segments are dropped
+	if marker == 0xED || marker == 0xFE {
+		_, err = j.src.Discard(n)
+		return unexpectedEOF(err)
+	}
+
+	j.out.Write([]byte{0xFF, marker})
+	j.out.Write(length)
+
+	_, err = io.CopyN(&j.out, j.src, int64(n))
+
+	return unexpectedEOF(err)
+}
+
+// exifOrientation returns the byte order and Orientation tag of an EXIF
+// APP1 payload
+func exifOrientation(segment []byte) (binary.ByteOrder, uint16, bool) {
+	tiff, ok := bytes.CutPrefix(segment, []byte("Exif\x00\x00"))
+
+	if !ok || len(tiff) < 8 {
+		return nil, 0, false
+	}
+
+	var order binary.ByteOrder
+
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, 0, false
+	}
+
+	offset := int(order.Uint32(tiff[4:8]))
+
+	if offset < 8 || offset+2 > len(tiff) {
+		return nil, 0, false
+	}
+
+	count := int(order.Uint16(tiff[offset:]))
+
+	for i := 0; i < count; i++ {
+		entry := offset + 2 + i*12
+
+		if entry+12 > len(tiff) {
+			return nil, 0, false
+		}
+
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			return order, order.Uint16(tiff[entry+8:]), true
+		}
+	}
+
+	return nil, 0, false
+}
+
+// orientationSegment builds an APP1 segment whose only EXIF tag is
+// Orientation
+func orientationSegment(order binary.ByteOrder, orientation uint16) []byte {
+	tiff := make([]byte, 26)
+
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 1)
+	order.PutUint16(tiff[10:], 0x0112)
+	order.PutUint16(tiff[12:], 3)
+	order.PutUint32(tiff[14:], 1)
+	order.PutUint16(tiff[18:], orientation)
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+
+	return append(segment, payload...)
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+// createThumbnails decodes the stored upload and writes one scaled copy
+// per Thumbnails entry next to it.
+func (t *Tools) createThumbnails(uploadDir, contentType string, f *UploadedFile) error {
+	store := t.storage()
+
+	src, err := store.Get(path.Join(uploadDir, f.NewFileName))
+
+	if err != nil {
+		return err
+	}
+
+	img, _, err := image.Decode(src)
+	src.Close()
+
+	if err != nil {
+		return err
+	}
+
+	ext := ".png"
+	if contentType == "image/jpeg" {
+		ext = ".jpg"
+	}
+
+	stem := strings.TrimSuffix(f.NewFileName, path.Ext(f.NewFileName))
+
+	for _, size := range t.Thumbnails {
+		width, height := fitSize(img.Bounds().Dx(), img.Bounds().Dy(), size.Width, size.Height)
+		thumb := resizeImage(img, width, height)
+
+		var buf bytes.Buffer
+
+		if ext == ".jpg" {
+			err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 85})
+		} else {
+			err = png.Encode(&buf, thumb)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		name, err := t.storeThumbnail(uploadDir, fmt.Sprintf("%s-%s%s", stem, size.Name, ext), &buf)
+
+		if err != nil {
+			return err
+		}
+
+		f.Thumbnails = append(f.Thumbnails, &Thumbnail{Name: size.Name, NewFileName: name, Width: width, Height: height})
+	}
+
+	return nil
+}
+
+// storeThumbnail puts a thumbnail into uploadDir as name, settling clashes
+// with files already stored by FileCollision, as for the upload itself
+func (t *Tools) storeThumbnail(uploadDir, name string, content io.Reader) (string, error) {
+	store := t.storage()
+
+	if t.ContentAddressed || t.FileCollision == CollisionOverwrite {
+		_, err := store.Put(path.Join(uploadDir, name), content)
+		return name, err
+	}
+
+	tmp := path.Join(uploadDir, ".upload-"+t.RandomString(25)+path.Ext(name))
+
+	if _, err := store.Put(tmp, content); err != nil {
+		return "", err
+	}
+
+	return t.commitUpload(tmp, uploadDir, name)
+}
+
+// fitSize scales width x height down to fit within maxWidth x maxHeight,
+// keeping the aspect ratio and never enlarging the image.
+func fitSize(width, height, maxWidth, maxHeight int) (int, int) {
+	scale := 1.0
+
+	if maxWidth > 0 && width > maxWidth {
+		scale = float64(maxWidth) / float64(width)
+	}
+
+	if maxHeight > 0 && float64(height)*scale > float64(maxHeight) {
+		scale = float64(maxHeight) / float64(height)
+	}
+
+	return max(1, int(float64(width)*scale+0.5)), max(1, int(float64(height)*scale+0.5))
+}
+
+// resizeImage scales src to width x height by averaging the source
+// pixels covered by each destination pixel.
+func resizeImage(src image.Image, width, height int) image.Image {
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0 := b.Min.Y + y*b.Dy()/height
+		y1 := max(b.Min.Y+(y+1)*b.Dy()/height, y0+1)
+
+		for x := 0; x < width; x++ {
+			x0 := b.Min.X + x*b.Dx()/width
+			x1 := max(b.Min.X+(x+1)*b.Dx()/width, x0+1)
+
+			var r, g, bl, a, n uint64
+
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca), n+1
+				}
+			}
+
+			dst.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: uint16(a / n)})
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,285 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// withExif inserts an APP1 segment carrying GPS data after the SOI marker
+func withExif(jpg []byte) []byte {
+	payload := []byte("Exif\x00\x00GPS 51.5074 N 0.1278 W")
+	segment := append([]byte{0xFF, 0xE1, 0x00, byte(len(payload) + 2)}, payload...)
+
+	out := append([]byte{}, jpg[:2]...)
+	out = append(out, segment...)
+
+	return append(out, jpg[2:]...)
+}
+
+func TestTools_UploadFilesStripsExif(t *testing.T) {
+	jpg, err := os.ReadFile("./testdata/pic.jpg")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body bytes.Buffer
+	contentType := multipartFile(t, &body, "pic.jpg", withExif(jpg))
+
+	request := httptest.NewRequest("POST", "/", &body)
+	request.Header.Add("Content-Type", contentType)
+
+	store := &MemoryStorage{}
+	testTools := Tools{Storage: store, StripImageMetadata: true}
+
+	uploadedFile, err := testTools.UploadOneFile(request, "uploads")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, _ := store.Get("uploads/" + uploadedFile.NewFileName)
+	stored, _ := io.ReadAll(f)
+
+	if bytes.Contains(stored, []byte("GPS")) || bytes.Contains(stored, []byte("Exif")) {
+		t.Error("expected EXIF segment to be stripped")
+	}
+
+	if !bytes.Equal(stored, jpg) {
+		t.Error("expected remaining segments and image data to be unchanged")
+	}
+
+	if _, _, err := image.Decode(bytes.NewReader(stored)); err != nil {
+		t.Errorf("stripped image no longer decodes: %s", err)
+	}
+}
+
+// withOrientation inserts an APP1 segment whose IFD0 holds a Make tag and
+// the given orientation, followed by GPS text
+func withOrientation(jpg []byte, order binary.ByteOrder, orientation uint16) []byte {
+	tiff := make([]byte, 34)
+
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 2)
+	order.PutUint16(tiff[10:], 0x010F)
+	order.PutUint16(tiff[12:], 2)
+	order.PutUint32(tiff[14:], 4)
+	copy(tiff[18:], "Cam\x00")
+	order.PutUint16(tiff[22:], 0x0112)
+	order.PutUint16(tiff[24:], 3)
+	order.PutUint32(tiff[26:], 1)
+	order.PutUint16(tiff[30:], orientation)
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	payload = append(payload, "\x00\x00\x00\x00GPS 51.5074 N 0.1278 W"...)
+	segment := append([]byte{0xFF, 0xE1, 0x00, byte(len(payload) + 2)}, payload...)
+
+	out := append([]byte{}, jpg[:2]...)
+	out = append(out, segment...)
+
+	return append(out, jpg[2:]...)
+}
+
+var keepOrientationTests = []struct {
+	name        string
+	order       binary.ByteOrder
+	orientation uint16
+	kept        bool
+}{
+	{name: "rotated little endian", order: binary.LittleEndian, orientation: 6, kept: true},
+	{name: "rotated big endian", order: binary.BigEndian, orientation: 6, kept: true},
+	{name: "upright", order: binary.BigEndian, orientation: 1},
+}
+
+func TestTools_StripJPEGMetadataKeepsOrientation(t *testing.T) {
+	jpg, err := os.ReadFile("./testdata/pic.jpg")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range keepOrientationTests {
+		stored, err := io.ReadAll(stripJPEGMetadata(bytes.NewReader(withOrientation(jpg, e.order, e.orientation))))
+
+		if err != nil {
+			t.Fatalf("%s: %s", e.name, err)
+		}
+
+		if bytes.Contains(stored, []byte("GPS")) || bytes.Contains(stored, []byte("Cam")) {
+			t.Errorf("%s: expected EXIF tags other than orientation to be stripped", e.name)
+		}
+
+		if _, _, err := image.Decode(bytes.NewReader(stored)); err != nil {
+			t.Errorf("%s: stripped image no longer decodes: %s", e.name, err)
+		}
+
+		if !e.kept {
+			if !bytes.Equal(stored, jpg) {
+				t.Errorf("%s: expected the EXIF segment to be dropped", e.name)
+			}
+
+			continue
+		}
+
+		if stored[2] != 0xFF || stored[3] != 0xE1 {
+			t.Fatalf("%s: expected an APP1 segment after SOI", e.name)
+		}
+
+		n := int(binary.BigEndian.Uint16(stored[4:6])) - 2
+		order, orientation, ok := exifOrientation(stored[6 : 6+n])
+
+		if !ok || order != e.order || orientation != e.orientation {
+			t.Errorf("%s: expected orientation %d, got %d (found %v)", e.name, e.orientation, orientation, ok)
+		}
+
+		if !bytes.Equal(stored[6+n:], jpg[2:]) {
+			t.Errorf("%s: expected remaining segments and image data to be unchanged", e.name)
+		}
+	}
+}
+
+var imageLimitTests = []struct {
+	name      string
+	maxWidth  int
+	maxHeight int
+	maxPixels int
+	expected  error
+}{
+	{name: "within limits", maxWidth: 5000, maxHeight: 5000, maxPixels: 25_000_000},
+	{name: "too wide", maxWidth: 100, expected: ErrImageTooLarge},
+	{name: "too tall", maxHeight: 100, expected: ErrImageTooLarge},
+	{name: "too many pixels", maxPixels: 10_000, expected: ErrImageTooLarge},
+}
+
+func TestTools_UploadFilesImageLimits(t *testing.T) {
+	body, contentType := newUploadBody(t, "img.png")
+
+	for _, e := range imageLimitTests {
+		request := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+		request.Header.Add("Content-Type", contentType)
+
+		store := &MemoryStorage{}
+		testTools := Tools{Storage: store, MaxImageWidth: e.maxWidth, MaxImageHeight: e.maxHeight, MaxImagePixels: e.maxPixels}
+
+		_, err := testTools.UploadOneFile(request, "uploads")
+
+		if !errors.Is(err, e.expected) {
+			t.Errorf("%s: expected %v got %v", e.name, e.expected, err)
+		}
+
+		if files, _ := store.List("uploads/"); e.expected != nil && len(files) != 0 {
+			t.Errorf("%s: expected rejected image not to be stored", e.name)
+		}
+	}
+}
+
+func TestTools_UploadFilesThumbnails(t *testing.T) {
+	body, contentType := newUploadBody(t, "img.png")
+
+	request := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+	request.Header.Add("Content-Type", contentType)
+
+	store := &MemoryStorage{}
+	testTools := Tools{
+		Storage: store,
+		Thumbnails: []ThumbnailSize{
+			{Name: "small", Width: 64, Height: 64},
+			{Name: "wide", Width: 200},
+		},
+	}
+
+	uploadedFile, err := testTools.UploadOneFile(request, "uploads", false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(uploadedFile.Thumbnails) != 2 {
+		t.Fatalf("expected 2 thumbnails, got %d", len(uploadedFile.Thumbnails))
+	}
+
+	for _, thumb := range uploadedFile.Thumbnails {
+		f, err := store.Get("uploads/" + thumb.NewFileName)
+
+		if err != nil {
+			t.Errorf("%s: thumbnail not stored: %s", thumb.Name, err)
+			continue
+		}
+
+		config, _, err := image.DecodeConfig(f)
+
+		if err != nil || config.Width != thumb.Width || config.Height != thumb.Height {
+			t.Errorf("%s: expected %dx%d, got %dx%d (%v)", thumb.Name, thumb.Width, thumb.Height, config.Width, config.Height, err)
+		}
+	}
+
+	if small := uploadedFile.Thumbnails[0]; small.NewFileName != "img-small.png" || small.Width > 64 || small.Height > 64 {
+		t.Errorf("unexpected small thumbnail %+v", small)
+	}
+
+	if wide := uploadedFile.Thumbnails[1]; wide.Width != 200 {
+		t.Errorf("expected wide thumbnail to be 200 pixels wide, got %d", wide.Width)
+	}
+}
+
+var thumbnailCollisionTests = []struct {
+	name      string
+	collision CollisionPolicy
+	expected  error
+	thumbnail string
+}{
+	{name: "error", collision: CollisionError, expected: ErrFileExists},
+	{name: "suffix", collision: CollisionSuffix, thumbnail: "img-small-1.png"},
+}
+
+func TestTools_UploadFilesThumbnailCollision(t *testing.T) {
+	for _, e := range thumbnailCollisionTests {
+		body, contentType := newUploadBody(t, "img.png")
+
+		request := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+		request.Header.Add("Content-Type", contentType)
+
+		store := &MemoryStorage{}
+		_, _ = store.Put("uploads/img-small.png", strings.NewReader("someone else's file"))
+
+		testTools := Tools{
+			Storage:       store,
+			FileCollision: e.collision,
+			Thumbnails:    []ThumbnailSize{{Name: "small", Width: 64, Height: 64}},
+		}
+
+		uploadedFile, err := testTools.UploadOneFile(request, "uploads", false)
+
+		if !errors.Is(err, e.expected) || (err == nil) != (e.expected == nil) {
+			t.Errorf("%s: expected %v, got %v", e.name, e.expected, err)
+		}
+
+		f, err := store.Get("uploads/img-small.png")
+
+		if err != nil {
+			t.Fatalf("%s: existing file was removed: %s", e.name, err)
+		}
+
+		if existing, _ := io.ReadAll(f); string(existing) != "someone else's file" {
+			t.Errorf("%s: existing file was overwritten", e.name)
+		}
+
+		if e.thumbnail != "" && (uploadedFile == nil || uploadedFile.Thumbnails[0].NewFileName != e.thumbnail) {
+			t.Errorf("%s: expected thumbnail %s, got %+v", e.name, e.thumbnail, uploadedFile)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Envelope is a JSONResponse whose Data has a known type. It is encoded
+// and decoded by way of JSONResponse, so both always look the same on the
+// wire; as for a JSONResponse with Data set, data is always included.
+type Envelope[T any] struct {
+	Error   bool
+	Message string
+	Data    T
+}
+
+// MarshalJSON encodes the envelope as a JSONResponse
+func (e Envelope[T]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(JSONResponse{Error: e.Error, Message: e.Message, Data: e.Data})
+}
+
+// UnmarshalJSON decodes a JSONResponse, with its data decoded into Data
+func (e *Envelope[T]) UnmarshalJSON(b []byte) error {
+	response := JSONResponse{Data: &e.Data}
+
+	if err := json.Unmarshal(b, &response); err != nil {
+		return err
+	}
+
+	e.Error, e.Message = response.Error, response.Message
+
+	return nil
+}
+
+// ReadJSONAs decodes the request body into a new T, with the same checks
+// as ReadJSON. The limits of the first of tools apply, or the defaults
+// when none is given.
+func ReadJSONAs[T any](w http.ResponseWriter, r *http.Request, tools ...*Tools) (T, error) {
+	var data T
+
+	t := &Tools{}
+	if len(tools) > 0 && tools[0] != nil {
+		t = tools[0]
+	}
+
+	err := t.ReadJSON(w, r, &data)
+
+	return data, err
+}
+
+// WriteEnvelope writes data wrapped in an Envelope with message
+func WriteEnvelope[T any](w http.ResponseWriter, status int, message string, data T, headers ...http.Header) error {
+	var t Tools
+
+	return t.WriteJSON(w, status, Envelope[T]{Message: message, Data: data}, headers...)
+}
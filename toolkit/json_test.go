@@ -0,0 +1,108 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type jsonPayload struct {
+	Foo string `json:"foo"`
+	Bar int    `json:"bar"`
+}
+
+var readJSONAsTests = []struct {
+	name          string
+	json          string
+	tools         *Tools
+	expected      jsonPayload
+	errorExpected bool
+}{
+	{name: "good json", json: `{"foo": "bar", "bar": 2}`, expected: jsonPayload{Foo: "bar", Bar: 2}},
+	{name: "incorrect type", json: `{"foo": 1}`, errorExpected: true},
+	{name: "unknown field", json: `{"baz": "qux"}`, errorExpected: true},
+	{name: "allow unknown field", json: `{"foo": "bar", "baz": "qux"}`, tools: &Tools{AllowUnknownFields: true}, expected: jsonPayload{Foo: "bar"}},
+	{name: "too large", json: `{"foo": "bar"}`, tools: &Tools{MaxJsonSize: 5}, errorExpected: true},
+}
+
+func TestReadJSONAs(t *testing.T) {
+	for _, e := range readJSONAsTests {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(e.json))
+		rr := httptest.NewRecorder()
+
+		var tools []*Tools
+		if e.tools != nil {
+			tools = append(tools, e.tools)
+		}
+
+		got, err := ReadJSONAs[jsonPayload](rr, req, tools...)
+
+		if e.errorExpected && err == nil {
+			t.Errorf("%s: err expected", e.name)
+		}
+
+		if !e.errorExpected && err != nil {
+			t.Errorf("%s: err not expected, got %s", e.name, err)
+		}
+
+		if !e.errorExpected && got != e.expected {
+			t.Errorf("%s: expected %+v, got %+v", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestWriteEnvelope(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	headers := make(http.Header)
+	headers.Add("foo", "bar")
+
+	err := WriteEnvelope(rr, http.StatusCreated, "created", []jsonPayload{{Foo: "a", Bar: 1}}, headers)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Code != http.StatusCreated || rr.Header().Get("foo") != "bar" || rr.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected response %d %v", rr.Code, rr.Header())
+	}
+
+	var envelope Envelope[[]jsonPayload]
+
+	if err = json.NewDecoder(rr.Body).Decode(&envelope); err != nil {
+		t.Fatal(err)
+	}
+
+	if envelope.Error || envelope.Message != "created" || len(envelope.Data) != 1 || envelope.Data[0].Foo != "a" {
+		t.Errorf("unexpected envelope %+v", envelope)
+	}
+}
+
+var envelopeEncodingTests = []struct {
+	name     string
+	envelope any
+	response JSONResponse
+}{
+	{name: "no data", envelope: Envelope[[]jsonPayload]{Message: "ok"}, response: JSONResponse{Message: "ok", Data: []jsonPayload(nil)}},
+	{name: "error", envelope: Envelope[*jsonPayload]{Error: true, Message: "bad"}, response: JSONResponse{Error: true, Message: "bad", Data: (*jsonPayload)(nil)}},
+	{name: "slice", envelope: Envelope[[]jsonPayload]{Data: []jsonPayload{{Foo: "a"}}}, response: JSONResponse{Data: []jsonPayload{{Foo: "a"}}}},
+	{name: "struct", envelope: Envelope[jsonPayload]{Message: "ok"}, response: JSONResponse{Message: "ok", Data: jsonPayload{}}},
+}
+
+func TestEnvelopeEncodesLikeJSONResponse(t *testing.T) {
+	for _, e := range envelopeEncodingTests {
+		envelope, err := json.Marshal(e.envelope)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		response, _ := json.Marshal(e.response)
+
+		if string(envelope) != string(response) {
+			t.Errorf("%s: expected %s, got %s", e.name, response, envelope)
+		}
+	}
+}
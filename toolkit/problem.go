@@ -0,0 +1,140 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Problem is an RFC 9457 problem details object. Extensions holds extra
+// members, such as the failing fields of a validation error, and is
+// encoded alongside the standard ones.
+type Problem struct {
+	Type       string         `json:"type"`
+	Title      string         `json:"title"`
+	Status     int            `json:"status"`
+	Detail     string         `json:"detail,omitempty"`
+	Instance   string         `json:"instance,omitempty"`
+	Extensions map[string]any `json:"-"`
+}
+
+// MarshalJSON encodes the standard members and Extensions as one object.
+// Extensions cannot replace the standard members.
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	members := make(map[string]any, len(p.Extensions)+5)
+
+	for key, value := range p.Extensions {
+		members[key] = value
+	}
+
+	members["type"] = p.Type
+	members["title"] = p.Title
+	members["status"] = p.Status
+
+	if p.Detail != "" {
+		members["detail"] = p.Detail
+	}
+
+	if p.Instance != "" {
+		members["instance"] = p.Instance
+	}
+
+	return json.Marshal(members)
+}
+
+// problemTypes names the problem type of each error this package returns
+var problemTypes = []struct {
+	err  error
+	slug string
+}{
+	{ErrValidation, "validation-failed"},
+	{ErrMalformedJSON, "malformed-json"},
+	{ErrUnknownField, "unknown-field"},
+	{ErrBodyTooLarge, "body-too-large"},
+	{ErrFileTooLarge, "file-too-large"},
+	{ErrRequestTooLarge, "request-too-large"},
+	{ErrTooManyFiles, "too-many-files"},
+	{ErrUnsafeFileName, "unsafe-file-name"},
+	{ErrFileExists, "file-exists"},
+	{ErrExtensionMismatch, "extension-mismatch"},
+	{ErrImageTooLarge, "image-too-large"},
+	{ErrInfectedFile, "infected-file"},
+	{ErrMissingField, "missing-field"},
+	{ErrNoFile, "no-file"},
+	{ErrInvalidSignature, "invalid-signature"},
+	{ErrURLExpired, "url-expired"},
+}
+
+// ProblemFor describes err as a Problem. Without a status, the status is
+// chosen as for ErrorJSON. Errors from this package get a type of
+// ProblemTypeBase followed by a name such as "validation-failed", and
+// their details as extension members: "errors" for a *ValidationError,
+// "offset" for a *MalformedJSONError, "field" for an *UnknownFieldError
+// and "limit" for a *BodyTooLargeError. Anything else, or everything
+// when ProblemTypeBase is empty, has the type "about:blank" and the
+// status text as its title.
+func (t *Tools) ProblemFor(err error, status ...int) *Problem {
+	statusCode := errorStatus(err)
+
+	if len(status) > 0 {
+		statusCode = status[0]
+	}
+
+	problem := &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(statusCode),
+		Status: statusCode,
+		Detail: err.Error(),
+	}
+
+	if t.ProblemTypeBase != "" {
+		for _, pt := range problemTypes {
+			if errors.Is(err, pt.err) {
+				problem.Type = t.ProblemTypeBase + pt.slug
+				problem.Title = pt.err.Error()
+				break
+			}
+		}
+	}
+
+	var validationError *ValidationError
+	var malformedJSONError *MalformedJSONError
+	var unknownFieldError *UnknownFieldError
+	var bodyTooLargeError *BodyTooLargeError
+
+	switch {
+	case errors.As(err, &validationError):
+		problem.Extensions = map[string]any{"errors": validationError.Fields}
+	case errors.As(err, &malformedJSONError) && malformedJSONError.Offset > 0:
+		problem.Extensions = map[string]any{"offset": malformedJSONError.Offset}
+	case errors.As(err, &unknownFieldError):
+		problem.Extensions = map[string]any{"field": unknownFieldError.Field}
+	case errors.As(err, &bodyTooLargeError):
+		problem.Extensions = map[string]any{"limit": bodyTooLargeError.Limit}
+	}
+
+	return problem
+}
+
+// WriteProblem writes problem with its status as application/problem+json
+func (t *Tools) WriteProblem(w http.ResponseWriter, problem *Problem, headers ...http.Header) error {
+	out, err := json.Marshal(problem)
+
+	if err != nil {
+		return err
+	}
+
+	if len(headers) > 0 {
+		for key, value := range headers[0] {
+			w.Header()[key] = value
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+
+	w.WriteHeader(problem.Status)
+
+	_, err = w.Write(out)
+
+	return err
+}
@@ -0,0 +1,129 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var readJSONErrorTests = []struct {
+	name    string
+	json    string
+	maxSize int
+	err     error
+	status  int
+}{
+	{name: "syntax error", json: `{"foo": "bar",}`, err: ErrMalformedJSON, status: http.StatusBadRequest},
+	{name: "truncated", json: `{"foo": "bar"`, err: ErrMalformedJSON, status: http.StatusBadRequest},
+	{name: "unknown field", json: `{"baz": "qux"}`, err: ErrUnknownField, status: http.StatusBadRequest},
+	{name: "too large", json: `{"foo": "barbarbarbar"}`, maxSize: 10, err: ErrBodyTooLarge, status: http.StatusRequestEntityTooLarge},
+}
+
+func TestTools_ReadJSONErrors(t *testing.T) {
+	for _, e := range readJSONErrorTests {
+		testTools := Tools{MaxJsonSize: e.maxSize}
+
+		var decoded struct {
+			Foo string `json:"foo"`
+		}
+
+		req := httptest.NewRequest("POST", "/", strings.NewReader(e.json))
+		err := testTools.ReadJSON(httptest.NewRecorder(), req, &decoded)
+
+		if !errors.Is(err, e.err) {
+			t.Errorf("%s: expected %v, got %v", e.name, e.err, err)
+			continue
+		}
+
+		rr := httptest.NewRecorder()
+		_ = testTools.ErrorJSON(rr, err)
+
+		if rr.Code != e.status {
+			t.Errorf("%s: expected status %d, got %d", e.name, e.status, rr.Code)
+		}
+	}
+
+	var unknown *UnknownFieldError
+	err := (&Tools{}).ReadJSON(httptest.NewRecorder(), httptest.NewRequest("POST", "/", strings.NewReader(`{"baz": 1}`)), &struct{}{})
+
+	if !errors.As(err, &unknown) || unknown.Field != "baz" {
+		t.Errorf("expected unknown field baz, got %v", err)
+	}
+}
+
+var problemTests = []struct {
+	name       string
+	err        error
+	status     []int
+	typeBase   string
+	wantStatus int
+	wantType   string
+	wantTitle  string
+	extension  string
+}{
+	{name: "plain error", err: errors.New("boom"), wantStatus: http.StatusBadRequest, wantType: "about:blank", wantTitle: "Bad Request"},
+	{name: "explicit status", err: errors.New("gone"), status: []int{http.StatusNotFound}, wantStatus: http.StatusNotFound, wantType: "about:blank", wantTitle: "Not Found"},
+	{name: "typed without base", err: &BodyTooLargeError{Limit: 10}, wantStatus: http.StatusRequestEntityTooLarge, wantType: "about:blank", wantTitle: "Request Entity Too Large", extension: "limit"},
+	{name: "typed with base", err: &UnknownFieldError{Field: "baz"}, typeBase: "https://example.com/problems/", wantStatus: http.StatusBadRequest, wantType: "https://example.com/problems/unknown-field", wantTitle: "unknown json field", extension: "field"},
+	{name: "malformed", err: &MalformedJSONError{Offset: 7}, typeBase: "/problems/", wantStatus: http.StatusBadRequest, wantType: "/problems/malformed-json", wantTitle: "malformed json", extension: "offset"},
+	{name: "validation", err: &ValidationError{Fields: []FieldError{{Field: "name", Rule: "required", Message: "is required"}}}, typeBase: "/problems/", wantStatus: http.StatusUnprocessableEntity, wantType: "/problems/validation-failed", wantTitle: "validation failed", extension: "errors"},
+}
+
+func TestTools_ErrorJSONProblem(t *testing.T) {
+	for _, e := range problemTests {
+		testTools := Tools{ProblemJSON: true, ProblemTypeBase: e.typeBase}
+		rr := httptest.NewRecorder()
+
+		if err := testTools.ErrorJSON(rr, e.err, e.status...); err != nil {
+			t.Fatal(err)
+		}
+
+		if rr.Code != e.wantStatus {
+			t.Errorf("%s: expected status %d, got %d", e.name, e.wantStatus, rr.Code)
+		}
+
+		if rr.Header().Get("Content-Type") != "application/problem+json" {
+			t.Errorf("%s: unexpected content type %s", e.name, rr.Header().Get("Content-Type"))
+		}
+
+		var problem map[string]any
+
+		if err := json.NewDecoder(rr.Body).Decode(&problem); err != nil {
+			t.Fatal(err)
+		}
+
+		if problem["type"] != e.wantType || problem["title"] != e.wantTitle || problem["status"] != float64(e.wantStatus) || problem["detail"] != e.err.Error() {
+			t.Errorf("%s: unexpected problem %v", e.name, problem)
+		}
+
+		if _, ok := problem[e.extension]; e.extension != "" && !ok {
+			t.Errorf("%s: expected extension member %q in %v", e.name, e.extension, problem)
+		}
+	}
+}
+
+func TestTools_WriteProblem(t *testing.T) {
+	var testTools Tools
+	rr := httptest.NewRecorder()
+
+	problem := testTools.ProblemFor(errors.New("no such order"), http.StatusNotFound)
+	problem.Instance = "/orders/42"
+	problem.Extensions = map[string]any{"order": 42, "status": "ignored"}
+
+	if err := testTools.WriteProblem(rr, problem); err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded map[string]any
+
+	if err := json.NewDecoder(rr.Body).Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded["instance"] != "/orders/42" || decoded["order"] != float64(42) || decoded["status"] != float64(http.StatusNotFound) {
+		t.Errorf("unexpected problem %v", decoded)
+	}
+}
@@ -0,0 +1,279 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// progressRetention is how long uploads stay visible to a ProgressTracker
+// after their last update
+const progressRetention = 10 * time.Minute
+
+// progressKeepAlive is how often an idle event stream sends a comment
+const progressKeepAlive = 15 * time.Second
+
+// progressInterval is the least time between two reports of an upload
+// that has not advanced by a percent of its total
+const progressInterval = 100 * time.Millisecond
+
+// UploadProgress is passed to Tools.OnProgress as an upload is read.
+// UploadID comes from the upload_id query parameter or X-Upload-ID header
+// of the request; Total is -1 when the request length is unknown.
+type UploadProgress struct {
+	UploadID     string `json:"upload_id"`
+	FileName     string `json:"file_name"`
+	BytesWritten int64  `json:"bytes_written"`
+	Total        int64  `json:"total"`
+	Done         bool   `json:"done"`
+	Error        string `json:"error,omitempty"`
+}
+
+// uploadID returns the client chosen id used to report progress for r
+func uploadID(r *http.Request) string {
+	if id := r.URL.Query().Get("upload_id"); id != "" {
+		return id
+	}
+
+	return r.Header.Get("X-Upload-ID")
+}
+
+// progressReader counts the bytes read from a request body and reports
+// them to OnProgress, at most every progressInterval or percent of the
+// total, and always when the upload finishes. A nil *progressReader
+// reports nothing.
+type progressReader struct {
+	io.ReadCloser
+	progress UploadProgress
+	report   func(UploadProgress)
+	reported time.Time
+	at       int64
+}
+
+func (t *Tools) newProgressReader(r *http.Request) *progressReader {
+	if t.OnProgress == nil {
+		return nil
+	}
+
+	p := &progressReader{
+		ReadCloser: r.Body,
+		progress:   UploadProgress{UploadID: uploadID(r), Total: r.ContentLength},
+		report:     t.OnProgress,
+	}
+
+	r.Body = p
+
+	return p
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.ReadCloser.Read(b)
+
+	if n > 0 {
+		p.progress.BytesWritten += int64(n)
+
+		if p.due() {
+			p.send()
+		}
+	}
+
+	return n, err
+}
+
+// due reports whether enough time or data has passed since the last report
+func (p *progressReader) due() bool {
+	if p.progress.Total > 0 && (p.progress.BytesWritten-p.at)*100 >= p.progress.Total {
+		return true
+	}
+
+	return time.Since(p.reported) >= progressInterval
+}
+
+// flush reports progress that was held back, if any
+func (p *progressReader) flush() {
+	if p != nil && p.at != p.progress.BytesWritten {
+		p.send()
+	}
+}
+
+func (p *progressReader) send() {
+	p.reported = time.Now()
+	p.at = p.progress.BytesWritten
+	p.report(p.progress)
+}
+
+func (p *progressReader) setFile(name string) {
+	if p != nil {
+		p.progress.FileName = name
+	}
+}
+
+func (p *progressReader) finish(err error) {
+	if p == nil {
+		return
+	}
+
+	p.progress.Done = true
+
+	if err != nil {
+		p.progress.Error = err.Error()
+	}
+
+	p.send()
+}
+
+// ProgressTracker remembers the latest UploadProgress of each upload and
+// serves it over HTTP. Use its Update method as Tools.OnProgress; the zero
+// value is ready to use.
+type ProgressTracker struct {
+	mu      sync.Mutex
+	uploads map[string]*trackedUpload
+}
+
+type trackedUpload struct {
+	progress UploadProgress
+	updated  time.Time
+	changed  chan struct{}
+}
+
+// Update records progress and wakes any event streams watching it.
+// Progress without an UploadID is ignored.
+func (p *ProgressTracker) Update(progress UploadProgress) {
+	if progress.UploadID == "" {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.uploads == nil {
+		p.uploads = make(map[string]*trackedUpload)
+	}
+
+	now := time.Now()
+	u, ok := p.uploads[progress.UploadID]
+
+	if !ok {
+		p.expire(now)
+
+		u = &trackedUpload{changed: make(chan struct{})}
+		p.uploads[progress.UploadID] = u
+	}
+
+	u.progress = progress
+	u.updated = now
+
+	close(u.changed)
+	u.changed = make(chan struct{})
+}
+
+// expire forgets uploads that have not been updated for
+// progressRetention, finished or abandoned. It runs as new uploads are
+// first seen rather than on every update.
+func (p *ProgressTracker) expire(now time.Time) {
+	for id, u := range p.uploads {
+		if now.Sub(u.updated) > progressRetention {
+			delete(p.uploads, id)
+		}
+	}
+}
+
+// Get returns the latest progress recorded for id
+func (p *ProgressTracker) Get(id string) (UploadProgress, bool) {
+	progress, _, ok := p.watch(id)
+	return progress, ok
+}
+
+func (p *ProgressTracker) watch(id string) (UploadProgress, <-chan struct{}, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	u, ok := p.uploads[id]
+
+	if !ok {
+		return UploadProgress{}, nil, false
+	}
+
+	return u.progress, u.changed, true
+}
+
+// ServeHTTP reports progress for the upload named by the id query
+// parameter. Clients accepting text/event-stream receive a Server-Sent
+// Event per update until the upload is done; others get a single JSON
+// document, or 404 when the upload is unknown.
+func (p *ProgressTracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+
+	if !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		progress, ok := p.Get(id)
+
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+
+		var t Tools
+		_ = t.WriteJSON(w, http.StatusOK, progress)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(progressKeepAlive)
+	defer keepAlive.Stop()
+
+	var last UploadProgress
+
+	for {
+		progress, changed, ok := p.watch(id)
+
+		if ok && progress != last {
+			data, err := json.Marshal(progress)
+
+			if err != nil {
+				return
+			}
+
+			fmt.Fprintf(w, "event: progress\ndata: %s\n\n", data)
+			flusher.Flush()
+
+			if progress.Done {
+				return
+			}
+
+			last = progress
+		}
+
+		// the upload may not have started yet, so poll until it appears
+		var poll <-chan time.Time
+		if changed == nil {
+			poll = time.After(100 * time.Millisecond)
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-changed:
+		case <-poll:
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package toolkit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestTools_UploadFilesProgress(t *testing.T) {
+	body, contentType := newUploadBody(t, "one.png", "two.png")
+
+	var tracker ProgressTracker
+	var calls int
+
+	testTools := Tools{
+		Storage: &MemoryStorage{},
+		OnProgress: func(p UploadProgress) {
+			calls++
+			tracker.Update(p)
+		},
+	}
+
+	request := httptest.NewRequest("POST", "/upload?upload_id=abc", bytes.NewReader(body))
+	request.Header.Add("Content-Type", contentType)
+
+	if _, err := testTools.UploadFiles(request, "uploads"); err != nil {
+		t.Fatal(err)
+	}
+
+	progress, ok := tracker.Get("abc")
+
+	if !ok || !progress.Done || progress.BytesWritten != int64(len(body)) || progress.Total != int64(len(body)) {
+		t.Errorf("unexpected final progress %+v", progress)
+	}
+
+	if progress.FileName != "two.png" {
+		t.Errorf("expected last file to be two.png, got %s", progress.FileName)
+	}
+
+	if calls < 3 {
+		t.Errorf("expected progress to be reported while reading, got %d calls", calls)
+	}
+
+	rr := httptest.NewRecorder()
+	tracker.ServeHTTP(rr, httptest.NewRequest("GET", "/progress?id=abc", nil))
+
+	var decoded UploadProgress
+
+	if err := json.NewDecoder(rr.Body).Decode(&decoded); err != nil || decoded != progress {
+		t.Errorf("expected JSON progress %+v, got %+v (%v)", progress, decoded, err)
+	}
+
+	rr = httptest.NewRecorder()
+	tracker.ServeHTTP(rr, httptest.NewRequest("GET", "/progress?id=missing", nil))
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown upload, got %d", rr.Code)
+	}
+}
+
+func TestProgressTracker_EventStream(t *testing.T) {
+	var tracker ProgressTracker
+
+	server := httptest.NewServer(&tracker)
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL+"?id=xyz", nil)
+	req.Header.Set("Accept", "text/event-stream")
+
+	res, err := server.Client().Do(req)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer res.Body.Close()
+
+	if res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected content type %s", res.Header.Get("Content-Type"))
+	}
+
+	go func() {
+		tracker.Update(UploadProgress{UploadID: "xyz", BytesWritten: 10, Total: 20})
+		tracker.Update(UploadProgress{UploadID: "xyz", BytesWritten: 20, Total: 20, Done: true})
+	}()
+
+	var events []UploadProgress
+	scanner := bufio.NewScanner(res.Body)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if data, ok := strings.CutPrefix(line, "data: "); ok {
+			var p UploadProgress
+			_ = json.Unmarshal([]byte(data), &p)
+			events = append(events, p)
+		}
+	}
+
+	if len(events) == 0 || !events[len(events)-1].Done || events[len(events)-1].BytesWritten != 20 {
+		t.Errorf("expected stream to end with the finished upload, got %+v", events)
+	}
+}
+
+var progressRateTests = []struct {
+	name     string
+	total    int64
+	maxCalls int
+}{
+	{name: "known total", total: 10_000, maxCalls: 110},
+	{name: "unknown total", total: -1, maxCalls: 20},
+}
+
+func TestProgressReader_RateLimited(t *testing.T) {
+	for _, e := range progressRateTests {
+		var reports []UploadProgress
+
+		p := &progressReader{
+			ReadCloser: io.NopCloser(iotest.OneByteReader(bytes.NewReader(make([]byte, 10_000)))),
+			progress:   UploadProgress{Total: e.total},
+			report:     func(progress UploadProgress) { reports = append(reports, progress) },
+		}
+
+		if _, err := io.Copy(io.Discard, p); err != nil {
+			t.Fatal(err)
+		}
+
+		p.finish(nil)
+
+		if len(reports) < 2 || len(reports) > e.maxCalls {
+			t.Errorf("%s: expected between 2 and %d reports, got %d", e.name, e.maxCalls, len(reports))
+			continue
+		}
+
+		if last := reports[len(reports)-1]; !last.Done || last.BytesWritten != 10_000 {
+			t.Errorf("%s: unexpected final report %+v", e.name, last)
+		}
+	}
+}
+
+func TestProgressTracker_ExpiresAbandonedUploads(t *testing.T) {
+	var tracker ProgressTracker
+
+	tracker.Update(UploadProgress{UploadID: "abandoned", BytesWritten: 10, Total: 100})
+	tracker.Update(UploadProgress{UploadID: "active", BytesWritten: 10, Total: 100})
+
+	tracker.mu.Lock()
+	tracker.uploads["abandoned"].updated = time.Now().Add(-progressRetention - time.Minute)
+	tracker.mu.Unlock()
+
+	tracker.Update(UploadProgress{UploadID: "new"})
+
+	if _, ok := tracker.Get("abandoned"); ok {
+		t.Error("expected the abandoned upload to expire")
+	}
+
+	if _, ok := tracker.Get("active"); !ok {
+		t.Error("expected the active upload to be kept")
+	}
+}
@@ -0,0 +1,443 @@
+package toolkit
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const tusVersion = "1.0.0"
+
+// defaultUploadExpiry is how long an unfinished resumable upload is kept
+const defaultUploadExpiry = 24 * time.Hour
+
+// ResumableUploads is an http.Handler implementing the core, creation,
+// expiration and termination parts of the tus resumable upload protocol:
+//
+//	POST   /          create an upload of Upload-Length bytes
+//	HEAD   /{id}      report the Upload-Offset received so far
+//	PATCH  /{id}      append a chunk starting at Upload-Offset
+//	DELETE /{id}      abandon an upload
+//
+// Chunks are staged on local disk in StagingDir. Once the last byte has
+// arrived the file goes through the same checks and storage as
+// UploadFiles, the result is passed to OnComplete and kept for Result
+// until it expires. Tools.OnProgress is called as chunks arrive, with the
+// upload id as UploadID. Upload state is held in memory, so uploads do
+// not survive a restart.
+type ResumableUploads struct {
+	Tools      *Tools
+	UploadDir  string
+	Rename     bool
+	StagingDir string
+	Expiry     time.Duration
+	OnComplete func(r *http.Request, f *UploadedFile)
+
+	mu      sync.Mutex
+	uploads map[string]*resumableUpload
+}
+
+type resumableUpload struct {
+	fileName string
+	length   int64
+	offset   int64
+	checked  bool
+	busy     bool
+	expires  time.Time
+	result   *UploadedFile
+}
+
+// ResumableUploads returns a handler storing completed uploads in uploadDir.
+// Files are renamed unless rename is false, as with UploadFiles.
+func (t *Tools) ResumableUploads(uploadDir string, rename ...bool) *ResumableUploads {
+	renameFile := true
+	if len(rename) > 0 {
+		renameFile = rename[0]
+	}
+
+	return &ResumableUploads{Tools: t, UploadDir: uploadDir, Rename: renameFile}
+}
+
+func (u *ResumableUploads) stagingDir() string {
+	if u.StagingDir != "" {
+		return u.StagingDir
+	}
+
+	return filepath.Join(os.TempDir(), "toolkit-uploads")
+}
+
+func (u *ResumableUploads) expiry() time.Duration {
+	if u.Expiry > 0 {
+		return u.Expiry
+	}
+
+	return defaultUploadExpiry
+}
+
+func (u *ResumableUploads) stagingFile(id string) string {
+	return filepath.Join(u.stagingDir(), id)
+}
+
+// Result returns the stored file for a completed upload
+func (u *ResumableUploads) Result(id string) (*UploadedFile, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	upload, ok := u.uploads[id]
+
+	if !ok || upload.result == nil {
+		return nil, false
+	}
+
+	return upload.result, true
+}
+
+// Expire removes uploads that have not been touched within Expiry,
+// including their staged data.
+func (u *ResumableUploads) Expire() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	now := time.Now()
+
+	for id, upload := range u.uploads {
+		if !upload.busy && now.After(upload.expires) {
+			delete(u.uploads, id)
+			_ = os.Remove(u.stagingFile(id))
+		}
+	}
+}
+
+func (u *ResumableUploads) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u.Expire()
+
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	if r.Method != http.MethodOptions && r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "unsupported tus version", http.StatusPreconditionFailed)
+		return
+	}
+
+	id := path.Base(r.URL.Path)
+
+	switch r.Method {
+	case http.MethodOptions:
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", "creation,expiration,termination")
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(u.Tools.maxFileSize(), 10))
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodPost:
+		u.create(w, r)
+	case http.MethodHead:
+		u.head(w, id)
+	case http.MethodPatch:
+		u.patch(w, r, id)
+	case http.MethodDelete:
+		u.terminate(w, r, id)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (u *ResumableUploads) create(w http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+
+	if err != nil || length <= 0 {
+		http.Error(w, "Upload-Length must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	if length > u.Tools.maxFileSize() {
+		http.Error(w, fmt.Sprintf("upload is larger than %d bytes", u.Tools.maxFileSize()), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	fileName := uploadMetadata(r.Header.Get("Upload-Metadata"))["filename"]
+
+	if fileName == "" {
+		fileName = "upload"
+	}
+
+	if err = os.MkdirAll(u.stagingDir(), 0700); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	id := u.Tools.RandomString(32)
+
+	f, err := os.Create(u.stagingFile(id))
+
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	f.Close()
+
+	upload := &resumableUpload{fileName: fileName, length: length, expires: time.Now().Add(u.expiry())}
+
+	u.mu.Lock()
+	if u.uploads == nil {
+		u.uploads = make(map[string]*resumableUpload)
+	}
+	u.uploads[id] = upload
+	u.mu.Unlock()
+
+	w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+id)
+	w.Header().Set("Upload-Expires", upload.expires.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (u *ResumableUploads) head(w http.ResponseWriter, id string) {
+	u.mu.Lock()
+	upload, ok := u.uploads[id]
+
+	if !ok {
+		u.mu.Unlock()
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	offset, length, expires := upload.offset, upload.length, upload.expires
+	u.mu.Unlock()
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(length, 10))
+	w.Header().Set("Upload-Expires", expires.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (u *ResumableUploads) patch(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+
+	if err != nil {
+		http.Error(w, "Upload-Offset must be an integer", http.StatusBadRequest)
+		return
+	}
+
+	u.mu.Lock()
+	upload, ok := u.uploads[id]
+
+	switch {
+	case !ok:
+		u.mu.Unlock()
+		http.NotFound(w, r)
+		return
+	case upload.busy:
+		u.mu.Unlock()
+		http.Error(w, "upload is already being written to", http.StatusConflict)
+		return
+	case upload.offset != offset || upload.result != nil:
+		u.mu.Unlock()
+		http.Error(w, "Upload-Offset does not match the current offset", http.StatusConflict)
+		return
+	}
+
+	upload.busy = true
+	u.mu.Unlock()
+
+	var progress *progressReader
+
+	if u.Tools.OnProgress != nil {
+		progress = &progressReader{
+			ReadCloser: r.Body,
+			progress:   UploadProgress{UploadID: id, FileName: upload.fileName, BytesWritten: offset, Total: upload.length},
+			report:     u.Tools.OnProgress,
+		}
+		r.Body = progress
+	}
+
+	written, err := u.appendChunk(id, upload, r.Body)
+
+	u.mu.Lock()
+	upload.offset += written
+	upload.expires = time.Now().Add(u.expiry())
+	u.mu.Unlock()
+
+	if err == nil {
+		err = u.checkType(id, upload)
+	}
+
+	if err == nil && upload.offset == upload.length {
+		err = u.complete(r, id, upload)
+	}
+
+	if err != nil || upload.offset == upload.length {
+		progress.finish(err)
+	} else {
+		progress.flush()
+	}
+
+	u.mu.Lock()
+	upload.busy = false
+	offset, expires := upload.offset, upload.expires
+	u.mu.Unlock()
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("Upload-Expires", expires.UTC().Format(http.TimeFormat))
+
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// appendChunk writes body to the staged file at the current offset,
+// refusing bytes beyond Upload-Length. Whatever was written before an
+// error is kept, so the client can resume from the new offset.
+func (u *ResumableUploads) appendChunk(id string, upload *resumableUpload, body io.Reader) (int64, error) {
+	f, err := os.OpenFile(u.stagingFile(id), os.O_WRONLY, 0600)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer f.Close()
+
+	if _, err = f.Seek(upload.offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	written, err := io.Copy(f, &maxSizeReader{
+		r:         body,
+		remaining: upload.length - upload.offset,
+		err:       &FileTooLargeError{FileName: upload.fileName, Limit: upload.length},
+	})
+
+	if err == nil {
+		err = f.Sync()
+	}
+
+	return written, err
+}
+
+// checkType rejects the upload as soon as enough bytes have arrived to
+// detect a type outside AllowedFileTypes.
+func (u *ResumableUploads) checkType(id string, upload *resumableUpload) error {
+	if upload.checked || (upload.offset < sniffLen && upload.offset < upload.length) {
+		return nil
+	}
+
+	f, err := os.Open(u.stagingFile(id))
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	buff := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, buff)
+
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+
+	if _, err = u.Tools.checkFileType(buff[:n]); err != nil {
+		u.remove(id)
+		return err
+	}
+
+	upload.checked = true
+
+	return nil
+}
+
+// complete runs the staged file through the upload pipeline
+func (u *ResumableUploads) complete(r *http.Request, id string, upload *resumableUpload) error {
+	f, err := os.Open(u.stagingFile(id))
+
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	counter := &countingReader{r: f}
+
+	uploadedFile, err := u.Tools.uploadPart(counter, upload.fileName, u.UploadDir, u.Rename)
+	f.Close()
+	_ = os.Remove(u.stagingFile(id))
+
+	if u.Tools.OnAudit != nil {
+		u.Tools.OnAudit(uploadEvent(r, u.UploadDir, upload.fileName, uploadedFile, counter.n, err, start))
+	}
+
+	if err != nil {
+		u.remove(id)
+		return err
+	}
+
+	u.mu.Lock()
+	upload.result = uploadedFile
+	u.mu.Unlock()
+
+	if u.OnComplete != nil {
+		u.OnComplete(r, uploadedFile)
+	}
+
+	return nil
+}
+
+func (u *ResumableUploads) terminate(w http.ResponseWriter, r *http.Request, id string) {
+	u.mu.Lock()
+	upload, ok := u.uploads[id]
+
+	switch {
+	case !ok:
+		u.mu.Unlock()
+		http.NotFound(w, r)
+		return
+	case upload.busy:
+		u.mu.Unlock()
+		http.Error(w, "upload is being written to", http.StatusConflict)
+		return
+	}
+
+	delete(u.uploads, id)
+	u.mu.Unlock()
+
+	_ = os.Remove(u.stagingFile(id))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (u *ResumableUploads) remove(id string) {
+	u.mu.Lock()
+	delete(u.uploads, id)
+	u.mu.Unlock()
+
+	_ = os.Remove(u.stagingFile(id))
+}
+
+// uploadMetadata decodes an Upload-Metadata header of comma separated
+// "key base64value" pairs.
+func uploadMetadata(header string) map[string]string {
+	metadata := make(map[string]string)
+
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+
+		if decoded, err := base64.StdEncoding.DecodeString(value); err == nil && key != "" {
+			metadata[key] = string(decoded)
+		}
+	}
+
+	return metadata
+}
@@ -0,0 +1,192 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func tusRequest(method, target string, body []byte, headers map[string]string) *http.Request {
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+	req.Header.Set("Tus-Resumable", tusVersion)
+
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	return req
+}
+
+func TestResumableUploads(t *testing.T) {
+	img, err := os.ReadFile("./testdata/img.png")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := &MemoryStorage{}
+	testTools := Tools{Storage: store, AllowedFileTypes: []string{"image/png"}}
+
+	var completed *UploadedFile
+
+	handler := testTools.ResumableUploads("uploads", false)
+	handler.StagingDir = t.TempDir()
+	handler.OnComplete = func(r *http.Request, f *UploadedFile) {
+		completed = f
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest("POST", "/files/", nil, map[string]string{
+		"Upload-Length":   strconv.Itoa(len(img)),
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("photo.png")),
+	}))
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create: expected 201 got %d", rr.Code)
+	}
+
+	location := rr.Header().Get("Location")
+	half := len(img) / 2
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest("PATCH", location, img[:half], map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": "0",
+	}))
+
+	if rr.Code != http.StatusNoContent || rr.Header().Get("Upload-Offset") != strconv.Itoa(half) {
+		t.Fatalf("first chunk: got %d with offset %s", rr.Code, rr.Header().Get("Upload-Offset"))
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest("HEAD", location, nil, nil))
+
+	if rr.Header().Get("Upload-Offset") != strconv.Itoa(half) || rr.Header().Get("Upload-Length") != strconv.Itoa(len(img)) {
+		t.Errorf("head: unexpected progress %s/%s", rr.Header().Get("Upload-Offset"), rr.Header().Get("Upload-Length"))
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest("PATCH", location, img[half:], map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": "0",
+	}))
+
+	if rr.Code != http.StatusConflict {
+		t.Errorf("wrong offset: expected 409 got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest("PATCH", location, img[half:], map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": strconv.Itoa(half),
+	}))
+
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("last chunk: expected 204 got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	result, ok := handler.Result(path.Base(location))
+
+	if !ok || completed == nil || result != completed || result.NewFileName != "photo.png" || result.FileSize != int64(len(img)) {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	if _, err := store.Stat("uploads/photo.png"); err != nil {
+		t.Errorf("expected completed upload to be stored: %s", err)
+	}
+
+	if _, err := os.Stat(handler.stagingFile(path.Base(location))); !os.IsNotExist(err) {
+		t.Error("expected staged data to be removed")
+	}
+}
+
+func TestResumableUploads_Limits(t *testing.T) {
+	testTools := Tools{Storage: &MemoryStorage{}, MaxFileSize: 1024, AllowedFileTypes: []string{"image/png"}}
+
+	handler := testTools.ResumableUploads("uploads")
+	handler.StagingDir = t.TempDir()
+	handler.Expiry = time.Millisecond
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest("POST", "/files", nil, map[string]string{"Upload-Length": "2048"}))
+
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 for upload over MaxFileSize, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest("POST", "/files", nil, map[string]string{"Upload-Length": "10"}))
+	location := rr.Header().Get("Location")
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest("PATCH", location, []byte("plain text"), map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": "0",
+	}))
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected disallowed type to be rejected, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest("POST", "/files", nil, map[string]string{"Upload-Length": "10"}))
+	location = rr.Header().Get("Location")
+
+	time.Sleep(5 * time.Millisecond)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest("HEAD", location, nil, nil))
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected expired upload to be gone, got %d", rr.Code)
+	}
+
+	if entries, _ := os.ReadDir(handler.StagingDir); len(entries) != 0 {
+		t.Errorf("expected staged data to be removed, found %d files", len(entries))
+	}
+}
+
+func TestResumableUploads_Terminate(t *testing.T) {
+	testTools := Tools{Storage: &MemoryStorage{}}
+
+	handler := testTools.ResumableUploads("uploads", false)
+	handler.StagingDir = t.TempDir()
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest("POST", "/files/", nil, map[string]string{"Upload-Length": "10"}))
+
+	location := rr.Header().Get("Location")
+	id := path.Base(location)
+
+	handler.mu.Lock()
+	handler.uploads[id].busy = true
+	handler.mu.Unlock()
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest("DELETE", location, nil, nil))
+
+	if _, ok := handler.uploads[id]; rr.Code != http.StatusConflict || !ok {
+		t.Fatalf("busy: expected 409 and the upload kept, got %d", rr.Code)
+	}
+
+	handler.mu.Lock()
+	handler.uploads[id].busy = false
+	handler.mu.Unlock()
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest("DELETE", location, nil, nil))
+
+	if _, ok := handler.uploads[id]; rr.Code != http.StatusNoContent || ok {
+		t.Fatalf("idle: expected 204 and the upload removed, got %d", rr.Code)
+	}
+
+	if _, err := os.Stat(handler.stagingFile(id)); !os.IsNotExist(err) {
+		t.Error("expected staged data to be removed")
+	}
+}
@@ -0,0 +1,272 @@
+package toolkit
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// errScanAborted is seen by a Scanner whose upload failed before the end
+var errScanAborted = errors.New("upload aborted")
+
+// Scanner inspects uploads before they are committed to storage. Scan
+// reads r to the end and returns an *InfectedFileError when the content
+// is malicious; any other error means the file could not be scanned,
+// and the upload is rejected as well.
+type Scanner interface {
+	Scan(fileName string, r io.Reader) error
+}
+
+// ScannerFunc adapts an ordinary function to the Scanner interface
+type ScannerFunc func(fileName string, r io.Reader) error
+
+func (f ScannerFunc) Scan(fileName string, r io.Reader) error {
+	return f(fileName, r)
+}
+
+// scanReader passes an upload through to storage while feeding a copy
+// to the Scanner. The end of the upload is only reported once the scan
+// has finished, so an infected file makes Put fail instead of committing.
+type scanReader struct {
+	r        io.Reader
+	fileName string
+	pipe     *io.PipeWriter
+	result   chan error
+	spool    *os.File
+	finished bool
+	err      error
+}
+
+// newScanReader starts Scanner on fileName. When QuarantineDir is set the
+// upload is also spooled to a temporary file so it can be quarantined.
+func (t *Tools) newScanReader(r io.Reader, fileName string) (*scanReader, error) {
+	s := &scanReader{r: r, fileName: fileName, result: make(chan error, 1)}
+
+	if t.QuarantineDir != "" {
+		spool, err := os.CreateTemp("", "toolkit-scan-*")
+
+		if err != nil {
+			return nil, err
+		}
+
+		s.spool = spool
+	}
+
+	pr, pw := io.Pipe()
+	s.pipe = pw
+
+	go func() {
+		err := t.Scanner.Scan(fileName, pr)
+
+		// a scanner that stops early must not block the upload
+		pr.CloseWithError(errScanAborted)
+		s.result <- err
+	}()
+
+	return s, nil
+}
+
+func (s *scanReader) Read(p []byte) (int, error) {
+	if s.finished {
+		if s.err != nil {
+			return 0, s.err
+		}
+
+		return 0, io.EOF
+	}
+
+	n, err := s.r.Read(p)
+
+	if n > 0 {
+		// only fails once the scanner has returned, which finish reports
+		_, _ = s.pipe.Write(p[:n])
+
+		if s.spool != nil {
+			if _, spoolErr := s.spool.Write(p[:n]); spoolErr != nil {
+				return n, spoolErr
+			}
+		}
+	}
+
+	if err == io.EOF {
+		if err = s.finish(nil); err == nil {
+			err = io.EOF
+		}
+	}
+
+	return n, err
+}
+
+// finish ends the scan, passing cause to the scanner as a read error
+// when the upload did not complete, and returns its verdict.
+func (s *scanReader) finish(cause error) error {
+	if s.finished {
+		return s.err
+	}
+
+	s.pipe.CloseWithError(cause)
+	s.err = <-s.result
+	s.finished = true
+
+	var infected *InfectedFileError
+
+	if errors.As(s.err, &infected) && infected.FileName == "" {
+		infected.FileName = s.fileName
+	} else if s.err != nil && infected == nil {
+		s.err = fmt.Errorf("could not scan file [%s]: %w", s.fileName, s.err)
+	}
+
+	return s.err
+}
+
+// close stops an unfinished scan and removes the spooled copy
+func (s *scanReader) close() {
+	_ = s.finish(errScanAborted)
+
+	if s.spool != nil {
+		s.spool.Close()
+		_ = os.Remove(s.spool.Name())
+	}
+}
+
+// quarantine stores the spooled copy of an infected upload in
+// QuarantineDir and records where it went on the error.
+func (t *Tools) quarantine(s *scanReader, name string) {
+	var infected *InfectedFileError
+
+	if s.spool == nil || !errors.As(s.err, &infected) {
+		return
+	}
+
+	if _, err := s.spool.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+
+	dst := path.Join(t.QuarantineDir, t.RandomString(10)+"-"+name)
+
+	if _, err := t.storage().Put(dst, s.spool); err == nil {
+		infected.Quarantined = dst
+	}
+}
+
+// ClamAVScanner scans uploads with a clamd daemon using its INSTREAM
+// command. Network is "tcp" or "unix" and defaults to tcp; Address
+// defaults to localhost:3310. Timeout bounds each exchange with the
+// daemon and defaults to 30 seconds. Uploads are sent in chunks of
+// ChunkSize bytes, 64KB by default; keep the daemon's StreamMaxLength
+// at least as large as MaxFileSize.
+type ClamAVScanner struct {
+	Network   string
+	Address   string
+	Timeout   time.Duration
+	ChunkSize int
+}
+
+func (c *ClamAVScanner) Scan(fileName string, r io.Reader) error {
+	network, address, timeout, chunkSize := c.Network, c.Address, c.Timeout, c.ChunkSize
+
+	if network == "" {
+		network = "tcp"
+	}
+
+	if address == "" {
+		address = "localhost:3310"
+	}
+
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+
+	if chunkSize == 0 {
+		chunkSize = 64 * 1024
+	}
+
+	conn, err := net.DialTimeout(network, address, timeout)
+
+	if err != nil {
+		return fmt.Errorf("clamav: %w", err)
+	}
+
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	if _, err = io.WriteString(conn, "zINSTREAM\x00"); err != nil {
+		return fmt.Errorf("clamav: %w", err)
+	}
+
+	chunk := make([]byte, 4+chunkSize)
+
+	for {
+		n, readErr := io.ReadFull(r, chunk[4:])
+
+		if n > 0 {
+			binary.BigEndian.PutUint32(chunk, uint32(n))
+			_ = conn.SetDeadline(time.Now().Add(timeout))
+
+			if _, err = conn.Write(chunk[:4+n]); err != nil {
+				// clamd hangs up on streams over StreamMaxLength, saying why
+				if reply, replyErr := clamdReply(conn); replyErr == nil {
+					return clamdResult(fileName, reply)
+				}
+
+				return fmt.Errorf("clamav: %w", err)
+			}
+		}
+
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+
+		if readErr != nil {
+			return readErr
+		}
+	}
+
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	if _, err = conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return fmt.Errorf("clamav: %w", err)
+	}
+
+	reply, err := clamdReply(conn)
+
+	if err != nil {
+		return fmt.Errorf("clamav: %w", err)
+	}
+
+	return clamdResult(fileName, reply)
+}
+
+// clamdReply reads a null terminated reply
+func clamdReply(conn net.Conn) (string, error) {
+	reply, err := bufio.NewReader(conn).ReadString(0)
+
+	if err != nil && (err != io.EOF || reply == "") {
+		return "", err
+	}
+
+	return strings.TrimRight(reply, "\x00\n"), nil
+}
+
+// clamdResult interprets replies such as "stream: OK" and
+// "stream: Eicar-Test-Signature FOUND".
+func clamdResult(fileName, reply string) error {
+	result := strings.TrimPrefix(reply, "stream: ")
+
+	switch {
+	case result == "OK":
+		return nil
+	case strings.HasSuffix(result, " FOUND"):
+		return &InfectedFileError{FileName: fileName, Signature: strings.TrimSuffix(result, " FOUND")}
+	default:
+		return fmt.Errorf("clamav: %s", reply)
+	}
+}
@@ -0,0 +1,174 @@
+package toolkit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// fakeClamd speaks enough of the clamd protocol to answer INSTREAM,
+// reporting the EICAR test string as infected.
+func fakeClamd(t *testing.T) (string, *[]int) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { listener.Close() })
+
+	var chunks []int
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+
+			if err != nil {
+				return
+			}
+
+			r := bufio.NewReader(conn)
+			command, _ := r.ReadString(0)
+
+			if command != "zINSTREAM\x00" {
+				_, _ = io.WriteString(conn, "UNKNOWN COMMAND\x00")
+				conn.Close()
+				continue
+			}
+
+			var data []byte
+
+			for {
+				var size uint32
+
+				if binary.Read(r, binary.BigEndian, &size) != nil || size == 0 {
+					break
+				}
+
+				chunk := make([]byte, size)
+				_, _ = io.ReadFull(r, chunk)
+				data = append(data, chunk...)
+				chunks = append(chunks, int(size))
+			}
+
+			if bytes.Contains(data, []byte(eicar)) {
+				_, _ = io.WriteString(conn, "stream: Eicar-Test-Signature FOUND\x00")
+			} else {
+				_, _ = io.WriteString(conn, "stream: OK\x00")
+			}
+
+			conn.Close()
+		}
+	}()
+
+	return listener.Addr().String(), &chunks
+}
+
+func TestClamAVScanner(t *testing.T) {
+	address, chunks := fakeClamd(t)
+
+	scanner := &ClamAVScanner{Address: address, ChunkSize: 16}
+
+	if err := scanner.Scan("clean.txt", strings.NewReader("nothing to see here, just text")); err != nil {
+		t.Errorf("expected clean file to pass, got %v", err)
+	}
+
+	if len(*chunks) != 2 || (*chunks)[0] != 16 {
+		t.Errorf("expected file to be sent in 16 byte chunks, got %v", *chunks)
+	}
+
+	err := scanner.Scan("eicar.txt", strings.NewReader(eicar))
+
+	var infected *InfectedFileError
+
+	if !errors.As(err, &infected) || infected.Signature != "Eicar-Test-Signature" || infected.FileName != "eicar.txt" {
+		t.Errorf("expected infected file error, got %v", err)
+	}
+}
+
+func TestTools_UploadFilesScanned(t *testing.T) {
+	address, _ := fakeClamd(t)
+
+	store := &MemoryStorage{}
+
+	testTools := Tools{
+		Storage:       store,
+		Scanner:       &ClamAVScanner{Address: address},
+		QuarantineDir: "quarantine",
+	}
+
+	body, contentType := newUploadBody(t, "img.png")
+
+	request := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+	request.Header.Add("Content-Type", contentType)
+
+	if _, err := testTools.UploadFiles(request, "uploads"); err != nil {
+		t.Fatalf("expected clean upload to be stored, got %s", err)
+	}
+
+	var infectedBody bytes.Buffer
+	contentType = multipartFile(t, &infectedBody, "eicar.txt", []byte(eicar))
+
+	request = httptest.NewRequest("POST", "/", &infectedBody)
+	request.Header.Add("Content-Type", contentType)
+
+	_, err := testTools.UploadFiles(request, "uploads", false)
+
+	var infected *InfectedFileError
+
+	if !errors.As(err, &infected) || infected.FileName != "eicar.txt" {
+		t.Fatalf("expected infected file error, got %v", err)
+	}
+
+	if errorStatus(err) != 422 {
+		t.Errorf("expected status 422 for infected file, got %d", errorStatus(err))
+	}
+
+	if _, err = store.Stat("uploads/eicar.txt"); err == nil {
+		t.Error("infected file was committed to the upload directory")
+	}
+
+	quarantined, _ := store.List("quarantine/")
+
+	if len(quarantined) != 1 || quarantined[0].Name != infected.Quarantined {
+		t.Fatalf("expected infected file in quarantine, got %v", quarantined)
+	}
+
+	f, _ := store.Get(infected.Quarantined)
+	data, _ := io.ReadAll(f)
+	f.Close()
+
+	if string(data) != eicar {
+		t.Errorf("unexpected quarantined content %q", data)
+	}
+}
+
+func TestTools_UploadFilesScannerUnavailable(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	address := listener.Addr().String()
+	listener.Close()
+
+	store := &MemoryStorage{}
+	testTools := Tools{Storage: store, Scanner: &ClamAVScanner{Address: address}}
+
+	body, contentType := newUploadBody(t, "img.png")
+
+	request := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+	request.Header.Add("Content-Type", contentType)
+
+	if _, err := testTools.UploadFiles(request, "uploads", false); err == nil {
+		t.Error("expected upload to fail when the scanner is unreachable")
+	}
+
+	if files, _ := store.List(""); len(files) != 0 {
+		t.Errorf("expected nothing to be stored, got %v", files)
+	}
+}
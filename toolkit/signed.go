@@ -0,0 +1,175 @@
+package toolkit
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SigningKey is a secret used to sign URLs. ID is included in every URL
+// it signs, so old keys can keep verifying links while a new one signs.
+type SigningKey struct {
+	ID     string
+	Secret []byte
+}
+
+// URLBinding ties a signed URL to the user it was issued to, the client
+// IP address it will be used from, or both.
+type URLBinding struct {
+	User string
+	IP   string
+}
+
+// signedParams are the query parameters added by SignURL
+var signedParams = []string{"expires", "kid", "bind", "sig"}
+
+// SignURL returns rawURL with an expiry ttl from now and an HMAC-SHA256
+// signature made with the first of SigningKeys. A binding restricts the
+// URL to one user and/or client IP; neither is revealed in the URL.
+func (t *Tools) SignURL(rawURL string, ttl time.Duration, binding ...URLBinding) (string, error) {
+	if len(t.SigningKeys) == 0 {
+		return "", errors.New("no signing key configured")
+	}
+
+	u, err := url.Parse(rawURL)
+
+	if err != nil {
+		return "", err
+	}
+
+	var bind URLBinding
+	if len(binding) > 0 {
+		bind = binding[0]
+	}
+
+	key := t.SigningKeys[0]
+	query := u.Query()
+
+	for _, param := range signedParams {
+		query.Del(param)
+	}
+
+	query.Set("expires", strconv.FormatInt(time.Now().Add(ttl).Unix(), 10))
+	query.Set("kid", key.ID)
+
+	var bound []string
+
+	if bind.User != "" {
+		bound = append(bound, "user")
+	}
+
+	if bind.IP != "" {
+		bound = append(bound, "ip")
+	}
+
+	if len(bound) > 0 {
+		query.Set("bind", strings.Join(bound, ","))
+	}
+
+	query.Set("sig", urlSignature(key, u.Path, query, bind))
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+// VerifySignedURL checks the signature and expiry SignURL added to the
+// request URL. For URLs bound to a user, user must return the user
+// making the request; IP bindings are checked against r.RemoteAddr.
+// It returns ErrURLExpired for expired links and ErrInvalidSignature for
+// anything else that does not verify.
+func (t *Tools) VerifySignedURL(r *http.Request, user ...func(r *http.Request) string) error {
+	query := r.URL.Query()
+
+	key, ok := t.signingKey(query.Get("kid"))
+
+	if !ok {
+		return ErrInvalidSignature
+	}
+
+	var bind URLBinding
+
+	for _, bound := range strings.Split(query.Get("bind"), ",") {
+		switch bound {
+		case "user":
+			if len(user) == 0 {
+				return ErrInvalidSignature
+			}
+
+			bind.User = user[0](r)
+		case "ip":
+			bind.IP = clientIP(r)
+		case "":
+		default:
+			return ErrInvalidSignature
+		}
+	}
+
+	sig := query.Get("sig")
+	query.Del("sig")
+
+	expected := urlSignature(key, r.URL.Path, query, bind)
+
+	if !hmac.Equal([]byte(sig), []byte(expected)) {
+		return ErrInvalidSignature
+	}
+
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	if time.Now().Unix() > expires {
+		return ErrURLExpired
+	}
+
+	return nil
+}
+
+// RequireSignedURL only lets requests with a valid signed URL through to
+// next, answering others with 403 Forbidden. user is passed on to
+// VerifySignedURL.
+func (t *Tools) RequireSignedURL(next http.Handler, user ...func(r *http.Request) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := t.VerifySignedURL(r, user...); err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (t *Tools) signingKey(id string) (SigningKey, bool) {
+	for _, key := range t.SigningKeys {
+		if key.ID == id {
+			return key, true
+		}
+	}
+
+	return SigningKey{}, false
+}
+
+// urlSignature signs the path, the canonical query and any binding
+func urlSignature(key SigningKey, urlPath string, query url.Values, bind URLBinding) string {
+	payload := strings.Join([]string{urlPath, query.Encode(), bind.User, bind.IP}, "\n")
+
+	return base64.RawURLEncoding.EncodeToString(hmacSHA256(key.Secret, payload))
+}
+
+// clientIP returns the host part of r.RemoteAddr
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,135 @@
+package toolkit
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func currentUser(r *http.Request) string {
+	return r.Header.Get("X-User")
+}
+
+var signedURLTests = []struct {
+	name    string
+	ttl     time.Duration
+	binding []URLBinding
+	tamper  func(u *url.URL)
+	user    string
+	ip      string
+	err     error
+}{
+	{name: "valid", ttl: time.Minute},
+	{name: "expired", ttl: -time.Minute, err: ErrURLExpired},
+	{name: "changed path", ttl: time.Minute, tamper: func(u *url.URL) { u.Path = "/files/other.pdf" }, err: ErrInvalidSignature},
+	{name: "extended expiry", ttl: time.Minute, tamper: func(u *url.URL) { setQuery(u, "expires", "9999999999") }, err: ErrInvalidSignature},
+	{name: "unknown key", ttl: time.Minute, tamper: func(u *url.URL) { setQuery(u, "kid", "nope") }, err: ErrInvalidSignature},
+	{name: "missing signature", ttl: time.Minute, tamper: func(u *url.URL) { setQuery(u, "sig", "") }, err: ErrInvalidSignature},
+	{name: "bound user", ttl: time.Minute, binding: []URLBinding{{User: "alice"}}, user: "alice"},
+	{name: "wrong user", ttl: time.Minute, binding: []URLBinding{{User: "alice"}}, user: "bob", err: ErrInvalidSignature},
+	{name: "binding removed", ttl: time.Minute, binding: []URLBinding{{User: "alice"}}, tamper: func(u *url.URL) { setQuery(u, "bind", "") }, err: ErrInvalidSignature},
+	{name: "bound ip", ttl: time.Minute, binding: []URLBinding{{IP: "10.0.0.1"}}, ip: "10.0.0.1"},
+	{name: "wrong ip", ttl: time.Minute, binding: []URLBinding{{IP: "10.0.0.1"}}, ip: "10.0.0.2", err: ErrInvalidSignature},
+}
+
+func setQuery(u *url.URL, key, value string) {
+	query := u.Query()
+	query.Set(key, value)
+	u.RawQuery = query.Encode()
+}
+
+func TestTools_SignURL(t *testing.T) {
+	testTools := Tools{SigningKeys: []SigningKey{{ID: "2024", Secret: []byte("new secret")}}}
+
+	for _, e := range signedURLTests {
+		signed, err := testTools.SignURL("https://example.com/files/report.pdf?download=1", e.ttl, e.binding...)
+
+		if err != nil {
+			t.Fatalf("%s: %s", e.name, err)
+		}
+
+		if strings.Contains(signed, "alice") || strings.Contains(signed, "10.0.0.1") {
+			t.Errorf("%s: signed url reveals its binding: %s", e.name, signed)
+		}
+
+		u, _ := url.Parse(signed)
+
+		if e.tamper != nil {
+			e.tamper(u)
+		}
+
+		req := httptest.NewRequest("GET", u.String(), nil)
+		req.Header.Set("X-User", e.user)
+
+		if e.ip != "" {
+			req.RemoteAddr = e.ip + ":5555"
+		}
+
+		err = testTools.VerifySignedURL(req, currentUser)
+
+		if !errors.Is(err, e.err) {
+			t.Errorf("%s: expected %v, got %v", e.name, e.err, err)
+		}
+	}
+}
+
+func TestTools_SignURLKeyRotation(t *testing.T) {
+	oldTools := Tools{SigningKeys: []SigningKey{{ID: "2023", Secret: []byte("old secret")}}}
+
+	signed, _ := oldTools.SignURL("/files/report.pdf", time.Hour)
+
+	rotated := Tools{SigningKeys: []SigningKey{
+		{ID: "2024", Secret: []byte("new secret")},
+		{ID: "2023", Secret: []byte("old secret")},
+	}}
+
+	if err := rotated.VerifySignedURL(httptest.NewRequest("GET", signed, nil)); err != nil {
+		t.Errorf("expected url signed with the previous key to verify, got %s", err)
+	}
+
+	resigned, _ := rotated.SignURL("/files/report.pdf", time.Hour)
+
+	if !strings.Contains(resigned, "kid=2024") {
+		t.Errorf("expected new urls to be signed with the first key, got %s", resigned)
+	}
+
+	retired := Tools{SigningKeys: []SigningKey{{ID: "2024", Secret: []byte("new secret")}}}
+
+	if err := retired.VerifySignedURL(httptest.NewRequest("GET", signed, nil)); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected url signed with a retired key to fail, got %v", err)
+	}
+
+	var noKeys Tools
+
+	if _, err := noKeys.SignURL("/files/report.pdf", time.Hour); err == nil {
+		t.Error("expected error signing without keys")
+	}
+}
+
+func TestTools_RequireSignedURL(t *testing.T) {
+	testTools := Tools{SigningKeys: []SigningKey{{ID: "1", Secret: []byte("secret")}}}
+
+	handler := testTools.RequireSignedURL(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		testTools.DownloadStaticFile(w, r, "testdata", "pic.jpg", "puppy.jpg")
+	}))
+
+	signed, _ := testTools.SignURL("/download", time.Minute)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", signed, nil))
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected signed request to be served, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/download", nil))
+
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("expected unsigned request to be forbidden, got %d", rr.Code)
+	}
+}
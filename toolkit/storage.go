@@ -0,0 +1,476 @@
+package toolkit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Storage is where UploadFiles writes files and DownloadStaticFile reads
+// them from. Names are slash separated keys; backends report missing
+// files with errors matching fs.ErrNotExist.
+type Storage interface {
+	Put(name string, r io.Reader) (int64, error)
+	Get(name string) (io.ReadSeekCloser, error)
+	Stat(name string) (*StoredFile, error)
+	Delete(name string) error
+	List(prefix string) ([]*StoredFile, error)
+}
+
+// Renamer is implemented by storages that can move a file without
+// copying it through the application.
+type Renamer interface {
+	Rename(from, to string) error
+}
+
+// ExclusiveRenamer is implemented by storages that can move a file to a
+// new name only when nothing is stored there, failing with an error
+// matching fs.ErrExist otherwise. Uploads use it to keep names without
+// ever overwriting a file stored concurrently.
+type ExclusiveRenamer interface {
+	RenameExclusive(from, to string) error
+}
+
+// StoredFile describes a file held by a Storage. ETag is a quoted entity
+// tag for the content, set by backends that know one without reading
+// the file.
+type StoredFile struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+	ETag    string
+}
+
+// storage returns the configured Storage, defaulting to the local
+// filesystem relative to the working directory.
+func (t *Tools) storage() Storage {
+	if t.Storage != nil {
+		return t.Storage
+	}
+
+	return LocalStorage{}
+}
+
+// moveFile renames from to to, copying and deleting when store does not
+// implement Renamer.
+func moveFile(store Storage, from, to string) error {
+	if r, ok := store.(Renamer); ok {
+		return r.Rename(from, to)
+	}
+
+	f, err := store.Get(from)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = store.Put(to, f)
+	f.Close()
+
+	if err != nil {
+		return err
+	}
+
+	return store.Delete(from)
+}
+
+// cleanPrefix normalises a List prefix the same way names are cleaned,
+// keeping a trailing slash so "dir/" only matches files inside dir.
+func cleanPrefix(prefix string) string {
+	if prefix == "" {
+		return ""
+	}
+
+	p := path.Clean(prefix)
+
+	switch {
+	case p == "." || p == "/":
+		return strings.TrimPrefix(p, ".")
+	case strings.HasSuffix(prefix, "/"):
+		return p + "/"
+	}
+
+	return p
+}
+
+// LocalStorage stores files on the local filesystem under Root.
+// An empty Root resolves names relative to the working directory.
+type LocalStorage struct {
+	Root string
+}
+
+func (s LocalStorage) path(name string) string {
+	return filepath.Join(s.Root, filepath.FromSlash(name))
+}
+
+// within reports fs.ErrNotExist when dir/name, once symlinks are
+// resolved, lies outside dir.
+func (s LocalStorage) within(dir, name string) error {
+	root, err := filepath.EvalSymlinks(s.path(dir))
+
+	if err != nil {
+		return err
+	}
+
+	target, err := filepath.EvalSymlinks(s.path(path.Join(dir, name)))
+
+	if err != nil {
+		return err
+	}
+
+	rel, err := filepath.Rel(root, target)
+
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return nil
+}
+
+// Put writes r to a temporary file next to name, syncs it and renames
+// it into place, so name never holds a partially written file.
+func (s LocalStorage) Put(name string, r io.Reader) (int64, error) {
+	fp := s.path(name)
+
+	if err := os.MkdirAll(filepath.Dir(fp), 0755); err != nil {
+		return 0, err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(fp), "."+filepath.Base(fp)+".tmp-*")
+
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := io.Copy(f, r)
+
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(f.Name(), fp)
+	}
+
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return 0, err
+	}
+
+	return n, nil
+}
+
+func (s LocalStorage) Get(name string) (io.ReadSeekCloser, error) {
+	f, err := os.Open(s.path(name))
+
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if info.IsDir() {
+		f.Close()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return f, nil
+}
+
+func (s LocalStorage) Stat(name string) (*StoredFile, error) {
+	info, err := os.Stat(s.path(name))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return &StoredFile{Name: name, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (s LocalStorage) Delete(name string) error {
+	return os.Remove(s.path(name))
+}
+
+func (s LocalStorage) Rename(from, to string) error {
+	fp := s.path(to)
+
+	if err := os.MkdirAll(filepath.Dir(fp), 0755); err != nil {
+		return err
+	}
+
+	return os.Rename(s.path(from), fp)
+}
+
+// RenameExclusive hard links from to to, which fails when to exists,
+// and then removes from. File systems without hard links fall back to
+// renameReserved.
+func (s LocalStorage) RenameExclusive(from, to string) error {
+	fp := s.path(to)
+
+	if err := os.MkdirAll(filepath.Dir(fp), 0755); err != nil {
+		return err
+	}
+
+	err := os.Link(s.path(from), fp)
+
+	switch {
+	case err == nil:
+		return os.Remove(s.path(from))
+	case errors.Is(err, fs.ErrExist), errors.Is(err, fs.ErrNotExist):
+		return err
+	}
+
+	return renameReserved(s.path(from), fp)
+}
+
+// renameReserved claims to by creating it exclusively and then renames
+// from over it, so a concurrent exclusive rename sees to as taken.
+func renameReserved(from, to string) error {
+	if _, err := os.Stat(from); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	f.Close()
+
+	if err = os.Rename(from, to); err != nil {
+		_ = os.Remove(to)
+		return err
+	}
+
+	return nil
+}
+
+// List walks the directory holding prefix and returns every file
+// whose name starts with prefix, sorted by name. Symlinks and other
+// irregular files are left out, so nothing outside Root is listed.
+func (s LocalStorage) List(prefix string) ([]*StoredFile, error) {
+	prefix = cleanPrefix(prefix)
+
+	dir := prefix
+	if !strings.HasSuffix(prefix, "/") {
+		dir = path.Dir(prefix)
+	}
+
+	var files []*StoredFile
+
+	err := filepath.WalkDir(s.path(dir), func(fp string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		rel := fp
+		if s.Root != "" {
+			if rel, err = filepath.Rel(s.Root, fp); err != nil {
+				return err
+			}
+		}
+
+		name := filepath.ToSlash(rel)
+
+		if !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+
+		info, err := d.Info()
+
+		if err != nil {
+			return err
+		}
+
+		files = append(files, &StoredFile{Name: name, Size: info.Size(), ModTime: info.ModTime()})
+
+		return nil
+	})
+
+	return files, err
+}
+
+// MemoryStorage keeps files in memory. It is intended for tests and
+// its zero value is ready to use.
+type MemoryStorage struct {
+	mu    sync.RWMutex
+	files map[string]*memoryFile
+}
+
+type memoryFile struct {
+	data    []byte
+	modTime time.Time
+	etag    string
+}
+
+type memoryReader struct {
+	*bytes.Reader
+}
+
+func (memoryReader) Close() error {
+	return nil
+}
+
+func (s *MemoryStorage) Put(name string, r io.Reader) (int64, error) {
+	data, err := io.ReadAll(r)
+
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.files == nil {
+		s.files = make(map[string]*memoryFile)
+	}
+
+	hash := sha256.Sum256(data)
+
+	s.files[path.Clean(name)] = &memoryFile{data: data, modTime: time.Now(), etag: `"` + hex.EncodeToString(hash[:]) + `"`}
+
+	return int64(len(data)), nil
+}
+
+func (s *MemoryStorage) file(op, name string) (*memoryFile, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.lookup(op, name)
+}
+
+// lookup finds name; the caller holds mu
+func (s *MemoryStorage) lookup(op, name string) (*memoryFile, error) {
+	f, ok := s.files[path.Clean(name)]
+
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+
+	return f, nil
+}
+
+func (s *MemoryStorage) Get(name string) (io.ReadSeekCloser, error) {
+	f, err := s.file("open", name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return memoryReader{bytes.NewReader(f.data)}, nil
+}
+
+func (s *MemoryStorage) Stat(name string) (*StoredFile, error) {
+	f, err := s.file("stat", name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &StoredFile{Name: path.Clean(name), Size: int64(len(f.data)), ModTime: f.modTime, ETag: f.etag}, nil
+}
+
+func (s *MemoryStorage) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.lookup("remove", name); err != nil {
+		return err
+	}
+
+	delete(s.files, path.Clean(name))
+
+	return nil
+}
+
+func (s *MemoryStorage) Rename(from, to string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := s.lookup("rename", from)
+
+	if err != nil {
+		return err
+	}
+
+	delete(s.files, path.Clean(from))
+	s.files[path.Clean(to)] = f
+
+	return nil
+}
+
+func (s *MemoryStorage) RenameExclusive(from, to string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := s.lookup("rename", from)
+
+	if err != nil {
+		return err
+	}
+
+	if _, ok := s.files[path.Clean(to)]; ok {
+		return &fs.PathError{Op: "rename", Path: to, Err: fs.ErrExist}
+	}
+
+	delete(s.files, path.Clean(from))
+	s.files[path.Clean(to)] = f
+
+	return nil
+}
+
+func (s *MemoryStorage) List(prefix string) ([]*StoredFile, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	prefix = cleanPrefix(prefix)
+
+	var files []*StoredFile
+
+	for name, f := range s.files {
+		if strings.HasPrefix(name, prefix) {
+			files = append(files, &StoredFile{Name: name, Size: int64(len(f.data)), ModTime: f.modTime, ETag: f.etag})
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+
+	return files, nil
+}
@@ -0,0 +1,395 @@
+package toolkit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// emptyPayloadHash is the hex SHA-256 of an empty request body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3Storage stores files in an S3 compatible bucket using path-style
+// requests signed with AWS Signature Version 4, e.g. against AWS S3,
+// MinIO or any local stand-in that speaks the same protocol.
+type S3Storage struct {
+	Endpoint  string
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	Client    *http.Client
+}
+
+// Put spools r to a temporary file first, because S3 needs the length
+// and hash of the body before the upload starts.
+func (s *S3Storage) Put(name string, r io.Reader) (int64, error) {
+	tmp, err := os.CreateTemp("", "toolkit-s3-*")
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+
+	n, err := io.Copy(io.MultiWriter(tmp, hash), r)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	res, err := s.do(http.MethodPut, name, nil, io.NopCloser(tmp), n, hex.EncodeToString(hash.Sum(nil)), nil)
+
+	if err != nil {
+		return 0, err
+	}
+
+	res.Body.Close()
+
+	return n, nil
+}
+
+func (s *S3Storage) Get(name string) (io.ReadSeekCloser, error) {
+	info, err := s.Stat(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3Object{storage: s, name: name, size: info.Size}, nil
+}
+
+func (s *S3Storage) Stat(name string) (*StoredFile, error) {
+	res, err := s.do(http.MethodHead, name, nil, nil, 0, emptyPayloadHash, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	res.Body.Close()
+
+	modTime, _ := http.ParseTime(res.Header.Get("Last-Modified"))
+
+	return &StoredFile{Name: name, Size: res.ContentLength, ModTime: modTime, ETag: res.Header.Get("ETag")}, nil
+}
+
+func (s *S3Storage) Delete(name string) error {
+	res, err := s.do(http.MethodDelete, name, nil, nil, 0, emptyPayloadHash, nil)
+
+	if err != nil {
+		return err
+	}
+
+	res.Body.Close()
+
+	return nil
+}
+
+// Rename copies the object server side with CopyObject and then
+// deletes the original.
+func (s *S3Storage) Rename(from, to string) error {
+	source := "/" + s3Escape(s.Bucket, false) + "/" + s3Escape(strings.TrimPrefix(cleanPrefix(from), "/"), true)
+
+	res, err := s.do(http.MethodPut, to, nil, nil, 0, emptyPayloadHash, http.Header{"X-Amz-Copy-Source": {source}})
+
+	if err != nil {
+		return err
+	}
+
+	res.Body.Close()
+
+	return s.Delete(from)
+}
+
+type s3ListResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+		ETag         string    `xml:"ETag"`
+	} `xml:"Contents"`
+}
+
+// List uses ListObjectsV2, following continuation tokens until every
+// key starting with prefix has been returned.
+func (s *S3Storage) List(prefix string) ([]*StoredFile, error) {
+	var files []*StoredFile
+
+	query := map[string]string{
+		"list-type": "2",
+		"prefix":    strings.TrimPrefix(cleanPrefix(prefix), "/"),
+	}
+
+	for {
+		res, err := s.do(http.MethodGet, "", query, nil, 0, emptyPayloadHash, nil)
+
+		if err != nil {
+			return nil, err
+		}
+
+		var result s3ListResult
+
+		err = xml.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range result.Contents {
+			files = append(files, &StoredFile{Name: c.Key, Size: c.Size, ModTime: c.LastModified, ETag: c.ETag})
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return files, nil
+		}
+
+		query["continuation-token"] = result.NextContinuationToken
+	}
+}
+
+// do sends a signed request for the object name, or for the bucket
+// itself when name is empty, and turns non-2xx responses into errors.
+func (s *S3Storage) do(method, name string, query map[string]string, body io.ReadCloser, length int64, payloadHash string, headers http.Header) (*http.Response, error) {
+	key := strings.TrimPrefix(cleanPrefix(name), "/")
+
+	rawURL := strings.TrimSuffix(s.Endpoint, "/") + "/" + s3Escape(s.Bucket, false)
+	if key != "" {
+		rawURL += "/" + s3Escape(key, true)
+	}
+
+	u, err := url.Parse(rawURL)
+
+	if err != nil {
+		return nil, err
+	}
+
+	u.RawQuery = s3Query(query)
+
+	if body != nil && length == 0 {
+		body = http.NoBody
+	}
+
+	req, err := http.NewRequest(method, u.String(), body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.ContentLength = length
+
+	for k, v := range headers {
+		req.Header[k] = v
+	}
+
+	s.sign(req, payloadHash, time.Now().UTC())
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return res, nil
+	}
+
+	res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, &fs.PathError{Op: strings.ToLower(method), Path: name, Err: fs.ErrNotExist}
+	}
+
+	return nil, fmt.Errorf("s3 %s [%s] failed with status %s", method, name, res.Status)
+}
+
+// sign adds the AWS Signature Version 4 headers to req, covering the
+// host and every x-amz-* header already set.
+func (s *S3Storage) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	region := s.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	canonicalHeaders := []string{"host:" + req.URL.Host}
+	signedHeaders := []string{"host"}
+
+	for k := range req.Header {
+		if k = strings.ToLower(k); strings.HasPrefix(k, "x-amz-") {
+			signedHeaders = append(signedHeaders, k)
+		}
+	}
+
+	sort.Strings(signedHeaders[1:])
+
+	for _, k := range signedHeaders[1:] {
+		canonicalHeaders = append(canonicalHeaders, k+":"+strings.TrimSpace(req.Header.Get(k)))
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		strings.Join(canonicalHeaders, "\n"),
+		"",
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := []byte("AWS4" + s.SecretKey)
+	for _, part := range []string{date, region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, strings.Join(signedHeaders, ";"), signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape percent-encodes everything except unreserved characters,
+// optionally leaving slashes alone, as Signature Version 4 requires.
+func s3Escape(s string, keepSlash bool) string {
+	var b strings.Builder
+
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', keepSlash && c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+// s3Query builds a canonical query string, sorted by key
+func s3Query(query map[string]string) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	params := make([]string, len(keys))
+	for i, k := range keys {
+		params[i] = s3Escape(k, false) + "=" + s3Escape(query[k], false)
+	}
+
+	return strings.Join(params, "&")
+}
+
+// s3Object reads an object lazily with ranged GET requests, so seeking
+// only costs a new request rather than downloading skipped bytes.
+type s3Object struct {
+	storage *S3Storage
+	name    string
+	size    int64
+	offset  int64
+	body    io.ReadCloser
+}
+
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+
+	if o.body == nil {
+		headers := http.Header{"Range": {"bytes=" + strconv.FormatInt(o.offset, 10) + "-"}}
+
+		res, err := o.storage.do(http.MethodGet, o.name, nil, nil, 0, emptyPayloadHash, headers)
+
+		if err != nil {
+			return 0, err
+		}
+
+		o.body = res.Body
+	}
+
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+
+	return n, err
+}
+
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += o.offset
+	case io.SeekEnd:
+		offset += o.size
+	}
+
+	if offset < 0 {
+		return 0, errors.New("s3: negative seek position")
+	}
+
+	if offset != o.offset {
+		o.Close()
+		o.offset = offset
+	}
+
+	return o.offset, nil
+}
+
+func (o *s3Object) Close() error {
+	if o.body == nil {
+		return nil
+	}
+
+	err := o.body.Close()
+	o.body = nil
+
+	return err
+}
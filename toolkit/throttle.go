@@ -0,0 +1,141 @@
+package toolkit
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// downloadRetryAfter is the Retry-After sent when every download slot is taken
+const downloadRetryAfter = 5 * time.Second
+
+// DownloadSlots caps how many downloads run at once. Every Tools, and
+// copy of one, holding the same DownloadSlots shares its limit.
+type DownloadSlots struct {
+	slots chan struct{}
+}
+
+// NewDownloadSlots returns DownloadSlots allowing n concurrent downloads
+func NewDownloadSlots(n int) *DownloadSlots {
+	return &DownloadSlots{slots: make(chan struct{}, n)}
+}
+
+// take claims a slot, returning the function that frees it, or false when
+// every slot is in use. A nil *DownloadSlots never runs out.
+func (s *DownloadSlots) take() (func(), bool) {
+	if s == nil {
+		return func() {}, true
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+		return func() { <-s.slots }, true
+	default:
+		return nil, false
+	}
+}
+
+// startDownload takes one of DownloadSlots and wraps w to send at most
+// DownloadRate bytes per second. When no slot is free it answers 429 Too
+// Many Requests and returns false; otherwise done must be called once the
+// download is over.
+func (t *Tools) startDownload(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func(), bool) {
+	done, ok := t.DownloadSlots.take()
+
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(downloadRetryAfter.Seconds())))
+		http.Error(w, "too many downloads in progress", http.StatusTooManyRequests)
+		return nil, nil, false
+	}
+
+	if t.DownloadRate > 0 {
+		burst := t.DownloadBurst
+		if burst <= 0 {
+			burst = t.DownloadRate
+		}
+
+		w = &throttledWriter{
+			ResponseWriter: w,
+			ctx:            r.Context(),
+			rate:           t.DownloadRate,
+			burst:          burst,
+			tokens:         float64(burst),
+			last:           time.Now(),
+		}
+	}
+
+	return w, done, true
+}
+
+// throttledWriter limits the bytes written per second with a token
+// bucket holding up to burst bytes, refilled at rate bytes per second
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx    context.Context
+	rate   int64
+	burst  int64
+	tokens float64
+	last   time.Time
+}
+
+func (tw *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+
+	for len(p) > 0 {
+		chunk := min(len(p), int(tw.burst))
+
+		if err := tw.wait(chunk); err != nil {
+			return written, err
+		}
+
+		n, err := tw.ResponseWriter.Write(p[:chunk])
+		written += n
+		tw.tokens -= float64(n)
+
+		if err != nil {
+			return written, err
+		}
+
+		p = p[chunk:]
+	}
+
+	return written, nil
+}
+
+// wait blocks until n bytes may be sent or the request is cancelled
+func (tw *throttledWriter) wait(n int) error {
+	now := time.Now()
+	tw.tokens = min(float64(tw.burst), tw.tokens+now.Sub(tw.last).Seconds()*float64(tw.rate))
+	tw.last = now
+
+	if tw.tokens >= float64(n) {
+		return nil
+	}
+
+	delay := time.Duration((float64(n) - tw.tokens) / float64(tw.rate) * float64(time.Second))
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-tw.ctx.Done():
+		return tw.ctx.Err()
+	case <-timer.C:
+	}
+
+	tw.tokens = float64(n)
+	tw.last = time.Now()
+
+	return nil
+}
+
+func (tw *throttledWriter) Flush() {
+	if flusher, ok := tw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (tw *throttledWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
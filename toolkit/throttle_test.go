@@ -0,0 +1,139 @@
+package toolkit
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var throttleTests = []struct {
+	name       string
+	rate       int64
+	burst      int64
+	size       int
+	minElapsed time.Duration
+}{
+	{name: "unlimited", size: 3000},
+	{name: "within burst", rate: 1000, burst: 4000, size: 3000},
+	{name: "beyond burst", rate: 10000, burst: 1000, size: 3000, minElapsed: 150 * time.Millisecond},
+	{name: "burst defaults to rate", rate: 10000, size: 12000, minElapsed: 150 * time.Millisecond},
+}
+
+func TestTools_DownloadRate(t *testing.T) {
+	modTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, e := range throttleTests {
+		testTools := Tools{DownloadRate: e.rate, DownloadBurst: e.burst}
+		content := strings.Repeat("x", e.size)
+
+		req := httptest.NewRequest("GET", "/", nil)
+		rr := httptest.NewRecorder()
+
+		start := time.Now()
+		testTools.DownloadReader(rr, req, "data.bin", modTime, strings.NewReader(content))
+		elapsed := time.Since(start)
+
+		if rr.Code != http.StatusOK || rr.Body.Len() != e.size {
+			t.Errorf("%s: expected %d bytes with status 200, got %d bytes with status %d", e.name, e.size, rr.Body.Len(), rr.Code)
+		}
+
+		if elapsed < e.minElapsed {
+			t.Errorf("%s: expected download to take at least %s, took %s", e.name, e.minElapsed, elapsed)
+		}
+
+		if e.minElapsed == 0 && elapsed > 100*time.Millisecond {
+			t.Errorf("%s: expected download not to be throttled, took %s", e.name, elapsed)
+		}
+	}
+}
+
+func TestTools_DownloadRateCancelled(t *testing.T) {
+	testTools := Tools{DownloadRate: 100}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	req := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	start := time.Now()
+	testTools.DownloadReader(rr, req, "data.bin", time.Now(), strings.NewReader(strings.Repeat("x", 1000)))
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected cancelled download to stop early, took %s", elapsed)
+	}
+
+	if rr.Body.Len() >= 1000 {
+		t.Errorf("expected cancelled download to be incomplete, got %d bytes", rr.Body.Len())
+	}
+}
+
+// blockingReader blocks its first Read until release is closed
+type blockingReader struct {
+	io.ReadSeeker
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingReader) Read(p []byte) (int, error) {
+	if b.started != nil {
+		close(b.started)
+		b.started = nil
+		<-b.release
+	}
+
+	return b.ReadSeeker.Read(p)
+}
+
+func TestTools_DownloadSlots(t *testing.T) {
+	testTools := Tools{DownloadSlots: NewDownloadSlots(1)}
+	w := httptest.NewRecorder()
+	w.Header().Set("ETag", `"fixed"`)
+
+	blocked := &blockingReader{
+		ReadSeeker: strings.NewReader(downloadContent),
+		started:    make(chan struct{}),
+		release:    make(chan struct{}),
+	}
+	started := blocked.started
+	finished := make(chan struct{})
+
+	go func() {
+		defer close(finished)
+		testTools.DownloadReader(w, httptest.NewRequest("GET", "/", nil), "slow.txt", time.Now(), blocked)
+	}()
+
+	<-started
+
+	// copies of Tools share their DownloadSlots
+	copied := testTools
+
+	rr := httptest.NewRecorder()
+	copied.DownloadReader(rr, httptest.NewRequest("GET", "/", nil), "fast.txt", time.Now(), strings.NewReader(downloadContent))
+
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("expected status %d while a download is in progress, got %d", http.StatusTooManyRequests, rr.Code)
+	}
+
+	if rr.Header().Get("Retry-After") == "" {
+		t.Error("expected a Retry-After header")
+	}
+
+	close(blocked.release)
+	<-finished
+
+	if w.Code != http.StatusOK || w.Body.String() != downloadContent {
+		t.Errorf("expected first download to complete, got status %d and %q", w.Code, w.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	testTools.DownloadReader(rr, httptest.NewRequest("GET", "/", nil), "fast.txt", time.Now(), strings.NewReader(downloadContent))
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status %d once the slot is free, got %d", http.StatusOK, rr.Code)
+	}
+}
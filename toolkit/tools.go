@@ -1,12 +1,14 @@
 package toolkit
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/rand"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
 	"os"
 	"path"
@@ -18,14 +20,98 @@ import (
 
 const randomStringSource = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 
+// sniffLen is the number of bytes peeked from each upload to detect its
+// content type; enough for a Detector to look inside zip containers.
+const sniffLen = 8192
+
 // Tools is the type to instantiate this module.
 // Any variable of this type will have access to
 // all the methods with receiver *Tools
 type Tools struct {
-	MaxFileSize        int
-	AllowedFileTypes   []string
-	MaxJsonSize        int
+	// MaxFileSize caps each uploaded file, 1GB by default
+	MaxFileSize int
+	// MaxRequestSize caps the whole upload request body
+	MaxRequestSize int64
+	// MaxFileCount caps the number of files in one upload request
+	MaxFileCount int
+	// AllOrNothing deletes the files already stored when an upload fails
+	AllOrNothing bool
+	// HashMD5 and HashCRC32C add those checksums to every UploadedFile
+	HashMD5    bool
+	HashCRC32C bool
+	// ContentAddressed names uploads after their SHA-256 and stores
+	// identical content once
+	ContentAddressed bool
+	// FileCollision settles clashes with stored files when uploads keep
+	// their names; see CollisionPolicy for storages that can race
+	FileCollision CollisionPolicy
+	// AllowedFileTypes lists the accepted detected content types
+	AllowedFileTypes []string
+	// AllowedExtensions lists the accepted file extensions
+	AllowedExtensions []string
+	// ExtensionMismatch handles extensions that do not fit the detected type
+	ExtensionMismatch ExtensionPolicy
+	// Detector replaces the built-in content type detection
+	Detector Detector
+	// MaxImageWidth, MaxImageHeight and MaxImagePixels cap the dimensions
+	// of JPEG, PNG and GIF uploads
+	MaxImageWidth  int
+	MaxImageHeight int
+	MaxImagePixels int
+	// StripImageMetadata removes EXIF, GPS, XMP and IPTC data from JPEGs,
+	// keeping only the EXIF orientation
+	StripImageMetadata bool
+	// Thumbnails are scaled copies stored next to each uploaded image;
+	// FileCollision settles names that are already taken
+	Thumbnails []ThumbnailSize
+	// OnProgress is called as upload bodies are read, at most every 100ms
+	// or percent of the total, and once more when they are done
+	OnProgress func(UploadProgress)
+	// FieldRules adds per-field limits to uploads, keyed by form field
+	FieldRules map[string]FieldRule
+	// Scanner checks every upload for malware before it is committed
+	Scanner Scanner
+	// QuarantineDir keeps infected uploads in Storage for inspection
+	QuarantineDir string
+	// MaxJsonSize caps JSON request bodies, 1MB by default
+	MaxJsonSize int
+	// AllowUnknownFields accepts JSON keys and form fields the destination
+	// has no place for; unknown file fields are skipped without being stored
 	AllowUnknownFields bool
+	// ValidateJSON runs Validate on everything ReadJSON decodes
+	ValidateJSON bool
+	// ProblemJSON makes ErrorJSON write RFC 9457 problem details
+	ProblemJSON bool
+	// ProblemTypeBase prefixes the problem type of this package's errors
+	ProblemTypeBase string
+	// Storage is where files are uploaded to and downloaded from, the local
+	// file system by default
+	Storage Storage
+	// Disposition asks browsers to save or display downloads
+	Disposition Disposition
+	// SigningKeys sign and verify download URLs; the first one signs
+	SigningKeys []SigningKey
+	// MaxArchiveSize caps the total size of files put into one archive
+	MaxArchiveSize int64
+	// Encodings are the content codings downloads may be compressed with,
+	// brotli and zstd siblings or gzip by default. Range requests are
+	// answered uncompressed. JSON responses are compressed by wrapping the
+	// handler with CompressResponses.
+	Encodings []Encoding
+	// WeakETags builds download ETags from size and modtime instead of a
+	// SHA-256 of the content, so requests do not read it all. Weak tags
+	// cannot satisfy If-Range, so interrupted downloads restart from the
+	// first byte.
+	WeakETags bool
+	// DownloadRate caps each download in bytes per second, after an
+	// initial DownloadBurst, which defaults to DownloadRate
+	DownloadRate  int64
+	DownloadBurst int64
+	// DownloadSlots, made by NewDownloadSlots, answers further downloads
+	// with 429 Too Many Requests while all of its slots are in use
+	DownloadSlots *DownloadSlots
+	// OnAudit receives an AuditEvent for every download and upload
+	OnAudit func(AuditEvent)
 }
 
 // RandomString() returns a string of random characters
@@ -44,114 +130,263 @@ func (t Tools) RandomString(n int) string {
 	return string(s)
 }
 
+// UploadedFile describes a stored upload and the form field it was sent
+// in. SHA256 is always set, MD5 and CRC32C only when HashMD5 and
+// HashCRC32C are enabled. Duplicate reports that ContentAddressed found
+// identical content already stored.
 type UploadedFile struct {
+	FieldName        string
 	NewFileName      string
 	OriginalFileName string
 	FileSize         int64
+	SHA256           string
+	MD5              string
+	CRC32C           string
+	Duplicate        bool
+	Thumbnails       []*Thumbnail
 }
 
+// UploadOneFile uploads the files of r like UploadFiles and returns the
+// first, or ErrNoFile when the request holds none.
 func (t *Tools) UploadOneFile(r *http.Request, uploadDir string, rename ...bool) (*UploadedFile, error) {
 	if files, err := t.UploadFiles(r, uploadDir, rename...); err != nil {
 		return nil, err
+	} else if len(files) == 0 {
+		return nil, ErrNoFile
 	} else {
 		return files[0], nil
 	}
 }
 
+// UploadFiles streams every file part of a multipart request into uploadDir
+// of the configured Storage, checking each against the upload options of
+// Tools as it arrives. Unless rename is false, files are given random
+// names. Non-file form fields are skipped; use UploadForm to keep them.
+//
+// Files stored before an error are returned alongside it, unless
+// AllOrNothing is set, in which case they are deleted again and no files
+// are returned.
 func (t *Tools) UploadFiles(r *http.Request, uploadDir string, rename ...bool) ([]*UploadedFile, error) {
+	result, err := t.UploadForm(r, uploadDir, rename...)
 
-	renameFile := true
-	if len(rename) > 0 {
-		renameFile = rename[0]
+	if result == nil {
+		return nil, err
 	}
 
-	var uploadedFiles []*UploadedFile
+	return result.Files, err
+}
 
-	if t.MaxFileSize == 0 {
-		t.MaxFileSize = 1024 * 1024 * 1024
+// removeUploads deletes files stored by a failed request
+func (t *Tools) removeUploads(uploadDir string, uploadedFiles []*UploadedFile) {
+	for _, f := range uploadedFiles {
+		if f.Duplicate {
+			continue
+		}
+
+		_ = t.storage().Delete(path.Join(filepath.ToSlash(uploadDir), f.NewFileName))
+
+		for _, thumb := range f.Thumbnails {
+			_ = t.storage().Delete(path.Join(filepath.ToSlash(uploadDir), thumb.NewFileName))
+		}
 	}
+}
+
+// uploadPart sniffs the content type of src, checks it against
+// AllowedFileTypes and puts it into uploadDir, failing as soon as
+// more than MaxFileSize bytes have been read.
+func (t *Tools) uploadPart(src io.Reader, rawName, uploadDir string, renameFile bool) (*UploadedFile, error) {
+	var uploadedFile UploadedFile
+
+	fileName := path.Base(strings.ReplaceAll(rawName, `\`, "/"))
+	dir := filepath.ToSlash(uploadDir)
+
+	br := bufio.NewReaderSize(src, sniffLen)
 
-	err := r.ParseMultipartForm(int64(t.MaxFileSize))
+	buff, err := br.Peek(sniffLen)
+
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	if len(buff) == 0 {
+		return nil, fmt.Errorf("file [%s] is empty", fileName)
+	}
+
+	fileType, err := t.checkFileType(buff)
 
 	if err != nil {
 		return nil, err
 	}
 
-	if err = t.CreateDirIfNotExists(uploadDir); err != nil {
+	ext, err := t.checkExtension(fileName, sanitizeExt(filepath.Ext(fileName)), fileType)
+
+	if err != nil {
 		return nil, err
 	}
 
-	for _, fHeaders := range r.MultipartForm.File {
-		for _, hdr := range fHeaders {
-			uploadedFiles, err = func(uploadedFiles []*UploadedFile) ([]*UploadedFile, error) {
-				var uploadedFile UploadedFile
-				infile, err := hdr.Open()
+	var wanted string
 
-				if err != nil {
-					return nil, err
-				}
-				defer infile.Close()
+	if renameFile || t.ContentAddressed {
+		uploadedFile.NewFileName = fmt.Sprintf("%s%s", t.RandomString(25), ext)
+	} else {
+		if wanted, err = t.SanitizeFileName(rawName); err != nil {
+			return nil, err
+		}
 
-				buff := make([]byte, 512)
-				_, err = infile.Read(buff)
+		wanted = strings.TrimSuffix(wanted, path.Ext(wanted)) + ext
 
-				if err != nil {
-					return nil, err
-				}
+		if uploadedFile.NewFileName, err = t.resolveCollision(dir, wanted); err != nil {
+			return nil, err
+		}
+	}
 
-				allowed := true
+	uploadedFile.OriginalFileName = fileName
 
-				fileType := http.DetectContentType(buff)
+	dst := path.Join(dir, uploadedFile.NewFileName)
 
-				if len(t.AllowedFileTypes) > 0 {
-					allowed = slices.Contains(t.AllowedFileTypes, fileType)
-				}
+	// kept names are committed once stored, so that a concurrent upload
+	// of the same name cannot be overwritten
+	exclusive := wanted != "" && t.FileCollision != CollisionOverwrite
 
-				if !allowed {
-					return nil, fmt.Errorf("file type [%s] is not allowed", fileType)
-				}
+	if t.ContentAddressed || exclusive {
+		dst = path.Join(dir, ".upload-"+t.RandomString(25)+ext)
+	}
 
-				_, err = infile.Seek(0, 0)
+	var content io.Reader = br
 
-				if err != nil {
-					return nil, err
-				}
+	isImage := decodableImage(fileType) && t.processesImages()
 
-				if renameFile {
-					uploadedFile.NewFileName = fmt.Sprintf("%s%s", t.RandomString(25), filepath.Ext(hdr.Filename))
-				} else {
-					uploadedFile.NewFileName = hdr.Filename
-				}
+	if isImage {
+		if content, err = t.checkImageSize(br, fileName); err != nil {
+			return nil, err
+		}
+	}
 
-				uploadedFile.OriginalFileName = hdr.Filename
-				var outfile *os.File
+	content = &maxSizeReader{
+		r:         content,
+		remaining: t.maxFileSize(),
+		err:       &FileTooLargeError{FileName: fileName, Limit: t.maxFileSize()},
+	}
 
-				defer outfile.Close()
+	if isImage && t.StripImageMetadata && fileType == "image/jpeg" {
+		content = stripJPEGMetadata(content)
+	}
 
-				if outfile, err = os.Create(filepath.Join(uploadDir, uploadedFile.NewFileName)); err != nil {
-					return nil, err
-				} else {
-					fileSize, err := io.Copy(outfile, infile)
-					if err != nil {
-						return nil, err
-					}
+	var scan *scanReader
 
-					uploadedFile.FileSize = fileSize
-				}
+	if t.Scanner != nil {
+		if scan, err = t.newScanReader(content, fileName); err != nil {
+			return nil, err
+		}
 
-				uploadedFiles = append(uploadedFiles, &uploadedFile)
+		defer scan.close()
+		content = scan
+	}
 
-				return uploadedFiles, nil
+	hashes := t.newUploadHashes()
 
-			}(uploadedFiles)
+	fileSize, err := t.storage().Put(dst, io.TeeReader(content, hashes))
 
-			if err != nil {
-				return uploadedFiles, err
-			}
+	if err != nil {
+		if scan != nil {
+			t.quarantine(scan, uploadedFile.NewFileName)
+		}
+
+		return nil, err
+	}
+
+	uploadedFile.FileSize = fileSize
+	hashes.apply(&uploadedFile)
+
+	if exclusive {
+		if uploadedFile.NewFileName, err = t.commitUpload(dst, dir, wanted); err != nil {
+			return nil, err
+		}
+	}
+
+	if t.ContentAddressed {
+		if err = t.storeContentAddressed(dst, dir, ext, &uploadedFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if isImage && len(t.Thumbnails) > 0 {
+		if err = t.createThumbnails(dir, fileType, &uploadedFile); err != nil {
+			t.removeUploads(dir, []*UploadedFile{&uploadedFile})
+			return nil, err
 		}
 	}
 
-	return uploadedFiles, nil
+	return &uploadedFile, nil
+}
+
+// maxFileSize returns MaxFileSize, defaulting to 1GB
+func (t *Tools) maxFileSize() int64 {
+	if t.MaxFileSize == 0 {
+		return 1024 * 1024 * 1024
+	}
+
+	return int64(t.MaxFileSize)
+}
+
+// checkFileType detects the content type of the first bytes of a file
+// and checks it against AllowedFileTypes.
+func (t *Tools) checkFileType(buff []byte) (string, error) {
+	allowed := true
+
+	fileType := t.detector().Detect(buff)
+
+	if len(t.AllowedFileTypes) > 0 {
+		allowed = slices.Contains(t.AllowedFileTypes, fileType)
+	}
+
+	if !allowed {
+		return "", fmt.Errorf("file type [%s] is not allowed", fileType)
+	}
+
+	return fileType, nil
+}
+
+// maxSizeReader reads from r until more than remaining bytes have been
+// read, at which point it returns err instead of the excess data.
+type maxSizeReader struct {
+	r         io.Reader
+	remaining int64
+	err       error
+}
+
+func (m *maxSizeReader) Read(p []byte) (int, error) {
+	if m.remaining < 0 {
+		return 0, m.err
+	}
+
+	if int64(len(p)) > m.remaining+1 {
+		p = p[:m.remaining+1]
+	}
+
+	n, err := m.r.Read(p)
+
+	if int64(n) > m.remaining {
+		n = int(m.remaining)
+		m.remaining = -1
+		return n, m.err
+	}
+
+	m.remaining -= int64(n)
+
+	return n, err
+}
+
+// uploadError converts the error http.MaxBytesReader produces once
+// MaxRequestSize is exceeded into a *RequestTooLargeError.
+func (t *Tools) uploadError(err error) error {
+	var maxBytesError *http.MaxBytesError
+
+	if errors.As(err, &maxBytesError) {
+		return &RequestTooLargeError{Limit: maxBytesError.Limit}
+	}
+
+	return err
 }
 
 func (t *Tools) CreateDirIfNotExists(path string) error {
@@ -184,13 +419,89 @@ func (t *Tools) Slugify(s string) (string, error) {
 	return slug, nil
 }
 
+// DownloadStaticFile sends the file p/file from the configured Storage,
+// asking the browser to save it as displayName. Range and conditional
+// requests are handled as described for DownloadReader.
+//
+// A precompressed sibling such as file.br or file.gz is sent instead when
+// the client accepts its encoding, except for Range requests; see
+// Encodings.
+//
+// file is treated as untrusted: names that would escape p, hidden files
+// and, on local disk, symlinks leading outside p get a 404, exactly like
+// files that do not exist.
+//
+// Downloads are limited by DownloadRate and DownloadSlots, and reported
+// to OnAudit.
 func (t *Tools) DownloadStaticFile(w http.ResponseWriter, r *http.Request, p, file, displayName string) {
-	fp := path.Join(p, file)
+	w, audit := t.auditDownload(w, r, path.Join(filepath.ToSlash(p), file), displayName)
+	defer audit.finish()
+
+	w, done, ok := t.startDownload(w, r)
+
+	if !ok {
+		return
+	}
+
+	defer done()
+
+	fp, err := t.jail(p, file)
+
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	store := t.storage()
+
+	info, err := store.Stat(fp)
 
-	w.Header().Set("Content-Disposition",
-		fmt.Sprintf("attachment; filename=\"%s\"", displayName))
+	if err != nil {
+		storageError(w, r, err)
+		return
+	}
+
+	f, err := store.Get(fp)
+
+	if err != nil {
+		storageError(w, r, err)
+		return
+	}
+
+	defer f.Close()
+
+	if sibling, siblingInfo, encoding, ok := t.precompressed(r, p, file); ok {
+		defer sibling.Close()
+
+		contentType, err := contentTypeOf(fp, f)
+
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Encoding", encoding.Name)
+		addVary(w.Header(), "Accept-Encoding")
+
+		info, f = siblingInfo, sibling
+	}
+
+	if info.ETag != "" && w.Header().Get("ETag") == "" {
+		w.Header().Set("ETag", info.ETag)
+	}
+
+	t.downloadReader(w, r, displayName, info.ModTime, f)
+}
+
+// storageError writes a 404 for missing files and a 500 for anything else
+func storageError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, fs.ErrNotExist) {
+		http.NotFound(w, r)
+		return
+	}
 
-	http.ServeFile(w, r, fp)
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 }
 
 type JSONResponse struct {
@@ -220,12 +531,13 @@ func (t *Tools) ReadJSON(w http.ResponseWriter, r *http.Request, data any) error
 		var syntaxError *json.SyntaxError
 		var unmarshalTypeError *json.UnmarshalTypeError
 		var invalidUnmarshalError *json.InvalidUnmarshalError
+		var maxBytesError *http.MaxBytesError
 
 		switch {
 		case errors.As(err, &syntaxError):
-			return fmt.Errorf("body contains malformed JSON at %d", syntaxError.Offset)
+			return &MalformedJSONError{Offset: syntaxError.Offset}
 		case errors.Is(err, io.ErrUnexpectedEOF):
-			return errors.New("body contains malformed JSON")
+			return &MalformedJSONError{}
 		case errors.As(err, &unmarshalTypeError):
 			if unmarshalTypeError.Field != "" {
 				return fmt.Errorf("body contains incorrect JSON type for field %q", unmarshalTypeError.Field)
@@ -233,11 +545,11 @@ func (t *Tools) ReadJSON(w http.ResponseWriter, r *http.Request, data any) error
 			return fmt.Errorf("body contains incorrect JSON type %d", unmarshalTypeError.Offset)
 		case errors.Is(err, io.EOF):
 			return errors.New("body must not be empty")
-		case strings.HasPrefix(err.Error(), "json: unknown field"):
-			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field")
-			return fmt.Errorf("body contains unknown key %s", fieldName)
-		case err.Error() == "http: request body too large":
-			return fmt.Errorf("body must not be larger than %d bytes", maxBytes)
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
+			return &UnknownFieldError{Field: strings.Trim(fieldName, `"`)}
+		case errors.As(err, &maxBytesError):
+			return &BodyTooLargeError{Limit: maxBytesError.Limit}
 		case errors.As(err, &invalidUnmarshalError):
 			return fmt.Errorf("error unmarshalling JSON: %s", err.Error())
 		default:
@@ -252,10 +564,16 @@ func (t *Tools) ReadJSON(w http.ResponseWriter, r *http.Request, data any) error
 		return errors.New("body must contain only one JSON values")
 	}
 
+	if t.ValidateJSON {
+		return t.Validate(data)
+	}
+
 	return nil
 
 }
 
+// WriteJSON writes data as JSON with status. Responses are sent as they
+// are; wrap the handler with CompressResponses to negotiate compression.
 func (t *Tools) WriteJSON(w http.ResponseWriter, status int, data any, headers ...http.Header) error {
 	out, err := json.Marshal(data)
 
@@ -282,8 +600,20 @@ func (t *Tools) WriteJSON(w http.ResponseWriter, status int, data any, headers .
 	return nil
 }
 
+// ErrorJSON writes err as a JSONResponse. Without a status, errors from
+// this package get the status that fits them, such as 413 for
+// *FileTooLargeError or 422 for *ValidationError, and anything else 400.
+// The failing fields of a *ValidationError are sent as Data.
+//
+// With ProblemJSON set the error is sent as RFC 9457 problem details
+// instead; see ProblemFor. As with WriteJSON, compression is left to
+// CompressResponses.
 func (t *Tools) ErrorJSON(w http.ResponseWriter, err error, status ...int) error {
-	statusCode := http.StatusBadRequest
+	if t.ProblemJSON {
+		return t.WriteProblem(w, t.ProblemFor(err, status...))
+	}
+
+	statusCode := errorStatus(err)
 
 	if len(status) > 0 {
 		statusCode = status[0]
@@ -295,6 +625,12 @@ func (t *Tools) ErrorJSON(w http.ResponseWriter, err error, status ...int) error
 
 	payload.Message = err.Error()
 
+	var validationError *ValidationError
+
+	if errors.As(err, &validationError) {
+		payload.Data = validationError.Fields
+	}
+
 	return t.WriteJSON(w, statusCode, payload)
 }
 
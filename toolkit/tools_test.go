@@ -2,6 +2,8 @@ package toolkit
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -160,6 +162,251 @@ func TestTools_UploadOneFile(t *testing.T) {
 	}
 }
 
+func TestTools_UploadOneFileNoFile(t *testing.T) {
+	body, contentType := newForm(t, formPart{field: "title", value: "no file here"})
+
+	request := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+	request.Header.Add("Content-Type", contentType)
+
+	testTools := Tools{Storage: &MemoryStorage{}}
+
+	if _, err := testTools.UploadOneFile(request, "uploads"); !errors.Is(err, ErrNoFile) {
+		t.Fatalf("expected ErrNoFile, got %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	_ = testTools.ErrorJSON(rr, ErrNoFile)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+// newUploadBody builds a multipart body with a text field followed by
+// one copy of testdata/img.png per name.
+func newUploadBody(t *testing.T, names ...string) ([]byte, string) {
+	img, err := os.ReadFile("./testdata/img.png")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	_ = writer.WriteField("title", "not a file")
+
+	for _, name := range names {
+		part, err := writer.CreateFormFile("file", name)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, _ = part.Write(img)
+	}
+
+	writer.Close()
+
+	return body.Bytes(), writer.FormDataContentType()
+}
+
+// multipartFile writes a multipart body holding a single file to w and
+// returns its content type.
+func multipartFile(t *testing.T, w io.Writer, name string, data []byte) string {
+	writer := multipart.NewWriter(w)
+
+	part, err := writer.CreateFormFile("file", name)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _ = part.Write(data)
+	writer.Close()
+
+	return writer.FormDataContentType()
+}
+
+func TestTools_UploadFilesStreaming(t *testing.T) {
+	body, contentType := newUploadBody(t, "one.png", "two.png")
+	img, _ := os.Stat("./testdata/img.png")
+
+	request := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+	request.Header.Add("Content-Type", contentType)
+
+	var testTools Tools
+
+	uploadedFiles, err := testTools.UploadFiles(request, "./testdata/uploads", false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(uploadedFiles) != 2 {
+		t.Fatalf("expected 2 files, got %d", len(uploadedFiles))
+	}
+
+	for i, name := range []string{"one.png", "two.png"} {
+		if uploadedFiles[i].NewFileName != name {
+			t.Errorf("expected %s got %s", name, uploadedFiles[i].NewFileName)
+		}
+
+		if uploadedFiles[i].FileSize != img.Size() {
+			t.Errorf("%s: expected size %d got %d", name, img.Size(), uploadedFiles[i].FileSize)
+		}
+
+		_ = os.Remove(fmt.Sprintf("./testdata/uploads/%s", name))
+	}
+}
+
+var uploadLimitTests = []struct {
+	name           string
+	maxFileSize    int
+	maxRequestSize int64
+	maxFileCount   int
+	expected       error
+}{
+	{name: "within limits", maxFileSize: 1024 * 1024, maxRequestSize: 4 * 1024 * 1024, maxFileCount: 2, expected: nil},
+	{name: "file too large", maxFileSize: 100, expected: ErrFileTooLarge},
+	{name: "request too large", maxRequestSize: 1000, expected: ErrRequestTooLarge},
+	{name: "too many files", maxFileCount: 1, expected: ErrTooManyFiles},
+}
+
+func TestTools_UploadFilesLimits(t *testing.T) {
+	body, contentType := newUploadBody(t, "one.png", "two.png")
+
+	for _, e := range uploadLimitTests {
+		request := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+		request.Header.Add("Content-Type", contentType)
+
+		// hide the length so MaxRequestSize is enforced while streaming
+		request.ContentLength = -1
+
+		testTools := Tools{
+			MaxFileSize:    e.maxFileSize,
+			MaxRequestSize: e.maxRequestSize,
+			MaxFileCount:   e.maxFileCount,
+		}
+
+		uploadedFiles, err := testTools.UploadFiles(request, "./testdata/uploads", false)
+
+		if !errors.Is(err, e.expected) {
+			t.Errorf("%s: expected %v got %v", e.name, e.expected, err)
+		}
+
+		for _, f := range uploadedFiles {
+			_ = os.Remove(fmt.Sprintf("./testdata/uploads/%s", f.NewFileName))
+		}
+
+		if _, err := os.Stat("./testdata/uploads/two.png"); e.expected != nil && !os.IsNotExist(err) {
+			t.Errorf("%s: expected rejected file to be removed", e.name)
+		}
+	}
+
+	var tooLarge *FileTooLargeError
+
+	request := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+	request.Header.Add("Content-Type", contentType)
+
+	testTools := Tools{MaxFileSize: 100}
+
+	_, err := testTools.UploadFiles(request, "./testdata/uploads", false)
+
+	if !errors.As(err, &tooLarge) || tooLarge.FileName != "one.png" || tooLarge.Limit != 100 {
+		t.Errorf("expected FileTooLargeError for one.png, got %v", err)
+	}
+}
+
+func TestTools_UploadFilesAllOrNothing(t *testing.T) {
+	body, contentType := newUploadBody(t, "one.png", "two.png")
+
+	for _, allOrNothing := range []bool{false, true} {
+		request := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+		request.Header.Add("Content-Type", contentType)
+
+		store := &MemoryStorage{}
+		testTools := Tools{MaxFileCount: 1, AllOrNothing: allOrNothing, Storage: store}
+
+		uploadedFiles, err := testTools.UploadFiles(request, "uploads", false)
+
+		if !errors.Is(err, ErrTooManyFiles) {
+			t.Errorf("expected ErrTooManyFiles, got %v", err)
+		}
+
+		files, _ := store.List("uploads/")
+
+		if allOrNothing && (len(uploadedFiles) != 0 || len(files) != 0) {
+			t.Errorf("all or nothing: expected no files, got %d returned and %d stored", len(uploadedFiles), len(files))
+		}
+
+		if !allOrNothing && (len(uploadedFiles) != 1 || len(files) != 1) {
+			t.Errorf("expected first file to be kept, got %d returned and %d stored", len(uploadedFiles), len(files))
+		}
+	}
+}
+
+func TestTools_UploadFilesContentAddressed(t *testing.T) {
+	img, _ := os.ReadFile("./testdata/img.png")
+	sum := sha256.Sum256(img)
+	expected := hex.EncodeToString(sum[:])
+
+	for name, store := range newTestStorages(t) {
+		body, contentType := newUploadBody(t, "one.png", "two.png")
+
+		request := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+		request.Header.Add("Content-Type", contentType)
+
+		testTools := Tools{Storage: store, ContentAddressed: true, HashMD5: true, HashCRC32C: true}
+
+		uploadedFiles, err := testTools.UploadFiles(request, "assets")
+
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		for i, f := range uploadedFiles {
+			if f.SHA256 != expected || f.NewFileName != expected+".png" {
+				t.Errorf("%s: expected file named after sha256 %s, got %s (%s)", name, expected, f.NewFileName, f.SHA256)
+			}
+
+			if f.MD5 == "" || f.CRC32C == "" {
+				t.Errorf("%s: expected optional checksums to be set", name)
+			}
+
+			if f.Duplicate != (i == 1) {
+				t.Errorf("%s: file %d: unexpected duplicate flag %t", name, i, f.Duplicate)
+			}
+		}
+
+		files, _ := store.List("assets/")
+
+		if len(files) != 1 {
+			t.Errorf("%s: expected one stored copy, found %d", name, len(files))
+		}
+	}
+}
+
+func TestTools_UploadFilesContentAddressedStatError(t *testing.T) {
+	body, contentType := newUploadBody(t, "img.png")
+
+	request := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+	request.Header.Add("Content-Type", contentType)
+
+	store := failingStatStorage{&MemoryStorage{}}
+	testTools := Tools{Storage: store, ContentAddressed: true}
+
+	_, err := testTools.UploadFiles(request, "assets")
+
+	if err == nil || err.Error() != "access denied" {
+		t.Errorf("expected the stat error, got %v", err)
+	}
+
+	if files, _ := store.List(""); len(files) != 0 {
+		t.Errorf("expected nothing stored, found %d files", len(files))
+	}
+}
+
 func TestTools_CreateDirIfNotExists(t *testing.T) {
 	var testTools Tools
 
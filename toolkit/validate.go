@@ -0,0 +1,364 @@
+package toolkit
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Validator is implemented by types with rules struct tags cannot express,
+// such as checks spanning several fields. Validate is called once the
+// validate tags of the value have been checked; returning a
+// *ValidationError reports several fields at once.
+type Validator interface {
+	Validate() error
+}
+
+// validateRegexps caches the compiled patterns of regexp rules
+var validateRegexps sync.Map
+
+// Validate checks data, a struct or a pointer to one, against the
+// validate tags of its fields and, for types implementing Validator, their
+// Validate method. Nested structs and slices of structs are checked too.
+//
+// Rules are separated by commas:
+//
+//	Name  string `json:"name" validate:"required,min=2,max=50"`
+//	Role  string `json:"role" validate:"oneof=admin editor viewer"`
+//	Email string `json:"email" validate:"required,email"`
+//	Site  string `json:"site" validate:"url"`
+//	Code  string `json:"code" validate:"len=6,regexp=^[A-Z0-9]+$"`
+//
+// min, max and len count the characters of strings and the items of
+// slices and maps, and compare numbers by value. Empty strings, slices
+// and maps and nil pointers only fail required. regexp takes the rest of
+// the tag, so it has to be the last rule.
+//
+// Fields are named as in their JSON encoding. Every failing field is
+// returned in a single *ValidationError; malformed tags return a plain
+// error describing the mistake. With ValidateJSON set, ReadJSON and
+// ReadJSONAs validate everything they decode.
+func (t *Tools) Validate(data any) error {
+	var fields []FieldError
+
+	if err := validateValue(reflect.ValueOf(data), "", &fields); err != nil {
+		return err
+	}
+
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+
+	return nil
+}
+
+// validateValue checks the fields of v, when it is a struct, and the
+// elements of v, when it is a slice or array, naming failures below prefix
+func validateValue(v reflect.Value, prefix string, fields *[]FieldError) error {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		return validateStruct(v, prefix, fields)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := validateValue(v.Index(i), fmt.Sprintf("%s[%d]", prefix, i), fields); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateStruct(v reflect.Value, prefix string, fields *[]FieldError) error {
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		tag := f.Tag.Get("validate")
+
+		if !f.IsExported() || tag == "-" {
+			continue
+		}
+
+		name := prefix
+		if !f.Anonymous {
+			name = joinField(prefix, jsonFieldName(f))
+		}
+
+		if tag != "" {
+			if err := checkRules(v.Field(i), name, tag, fields); err != nil {
+				return err
+			}
+		}
+
+		if err := validateValue(v.Field(i), name, fields); err != nil {
+			return err
+		}
+	}
+
+	var validator Validator
+
+	if v.CanAddr() && v.Addr().Type().Implements(reflect.TypeOf(&validator).Elem()) {
+		validator = v.Addr().Interface().(Validator)
+	} else if impl, ok := v.Interface().(Validator); ok {
+		validator = impl
+	}
+
+	if validator == nil {
+		return nil
+	}
+
+	err := validator.Validate()
+
+	var validationError *ValidationError
+
+	switch {
+	case err == nil:
+	case errors.As(err, &validationError):
+		for _, fe := range validationError.Fields {
+			fe.Field = joinField(prefix, fe.Field)
+			*fields = append(*fields, fe)
+		}
+	default:
+		*fields = append(*fields, FieldError{Field: prefix, Rule: "validate", Message: err.Error()})
+	}
+
+	return nil
+}
+
+// checkRules applies the rules of a validate tag to v, recording the
+// first one that fails
+func checkRules(v reflect.Value, name, tag string, fields *[]FieldError) error {
+	rules := splitRules(tag)
+	required := false
+
+	for _, rule := range rules {
+		if rule == "required" {
+			required = true
+		}
+	}
+
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			if required {
+				*fields = append(*fields, FieldError{Field: name, Rule: "required", Message: "is required"})
+			}
+
+			return nil
+		}
+
+		v = v.Elem()
+	}
+
+	if required && isEmpty(v) {
+		*fields = append(*fields, FieldError{Field: name, Rule: "required", Message: "is required"})
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		if v.Len() == 0 {
+			return nil
+		}
+	}
+
+	for _, rule := range rules {
+		message, err := checkRule(v, rule)
+
+		if err != nil {
+			return fmt.Errorf("invalid validate rule %q on field %q: %w", rule, name, err)
+		}
+
+		if message != "" {
+			ruleName, _, _ := strings.Cut(rule, "=")
+			*fields = append(*fields, FieldError{Field: name, Rule: ruleName, Message: message})
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// checkRule returns why v fails rule, or an empty string when it passes
+func checkRule(v reflect.Value, rule string) (string, error) {
+	ruleName, param, _ := strings.Cut(rule, "=")
+
+	switch ruleName {
+	case "required":
+		return "", nil
+	case "min", "max", "len":
+		return checkSize(v, ruleName, param)
+	case "oneof":
+		options := strings.Fields(param)
+		value := fmt.Sprint(v.Interface())
+
+		for _, option := range options {
+			if value == option {
+				return "", nil
+			}
+		}
+
+		return fmt.Sprintf("must be one of [%s]", strings.Join(options, ", ")), nil
+	}
+
+	if v.Kind() != reflect.String {
+		return "", fmt.Errorf("%s only applies to strings", ruleName)
+	}
+
+	s := v.String()
+
+	switch ruleName {
+	case "email":
+		if addr, err := mail.ParseAddress(s); err != nil || addr.Address != s {
+			return "must be a valid email address", nil
+		}
+	case "url":
+		if u, err := url.Parse(s); err != nil || u.Scheme == "" || u.Host == "" {
+			return "must be a valid url", nil
+		}
+	case "regexp":
+		re, err := compileRule(param)
+
+		if err != nil {
+			return "", err
+		}
+
+		if !re.MatchString(s) {
+			return fmt.Sprintf("must match [%s]", param), nil
+		}
+	default:
+		return "", errors.New("unknown rule")
+	}
+
+	return "", nil
+}
+
+// checkSize compares the length of strings, slices and maps, or the value
+// of numbers, with param
+func checkSize(v reflect.Value, ruleName, param string) (string, error) {
+	limit, err := strconv.ParseFloat(param, 64)
+
+	if err != nil {
+		return "", err
+	}
+
+	var size float64
+	var unit string
+
+	switch v.Kind() {
+	case reflect.String:
+		size, unit = float64(utf8.RuneCountInString(v.String())), "characters"
+	case reflect.Slice, reflect.Map, reflect.Array:
+		size, unit = float64(v.Len()), "items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		size = float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		size = float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		size = v.Float()
+	default:
+		return "", fmt.Errorf("%s does not apply to %s", ruleName, v.Kind())
+	}
+
+	var failed bool
+	var bound string
+
+	switch ruleName {
+	case "min":
+		failed, bound = size < limit, "at least"
+	case "max":
+		failed, bound = size > limit, "at most"
+	default:
+		failed, bound = size != limit, "exactly"
+	}
+
+	switch {
+	case !failed:
+		return "", nil
+	case unit == "characters":
+		return fmt.Sprintf("must be %s %s characters long", bound, param), nil
+	case unit == "items":
+		return fmt.Sprintf("must contain %s %s items", bound, param), nil
+	default:
+		return fmt.Sprintf("must be %s %s", bound, param), nil
+	}
+}
+
+// splitRules splits a validate tag at its commas, leaving a regexp rule,
+// which may contain commas itself, whole
+func splitRules(tag string) []string {
+	var rules []string
+
+	for tag != "" {
+		if strings.HasPrefix(tag, "regexp=") {
+			return append(rules, tag)
+		}
+
+		rule, rest, _ := strings.Cut(tag, ",")
+		rules = append(rules, strings.TrimSpace(rule))
+		tag = rest
+	}
+
+	return rules
+}
+
+func compileRule(pattern string) (*regexp.Regexp, error) {
+	if re, ok := validateRegexps.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+
+	re, err := regexp.Compile(pattern)
+
+	if err != nil {
+		return nil, err
+	}
+
+	validateRegexps.Store(pattern, re)
+
+	return re, nil
+}
+
+// isEmpty reports whether v holds no value for required
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	default:
+		return v.IsZero()
+	}
+}
+
+// jsonFieldName returns the name a struct field has in JSON
+func jsonFieldName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+
+	if name == "" || name == "-" {
+		return f.Name
+	}
+
+	return name
+}
+
+func joinField(prefix, name string) string {
+	switch {
+	case prefix == "":
+		return name
+	case name == "":
+		return prefix
+	default:
+		return prefix + "." + name
+	}
+}
@@ -0,0 +1,152 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type validateAddress struct {
+	City string `json:"city" validate:"required"`
+	Zip  string `json:"zip" validate:"len=5,regexp=^[0-9]{5}$"`
+}
+
+type validateSignup struct {
+	Name      string            `json:"name" validate:"required,min=2,max=10"`
+	Email     string            `json:"email" validate:"required,email"`
+	Site      string            `json:"site,omitempty" validate:"url"`
+	Role      string            `json:"role" validate:"oneof=admin editor viewer"`
+	Age       int               `json:"age" validate:"min=18,max=130"`
+	Tags      []string          `json:"tags" validate:"max=2"`
+	Nickname  *string           `json:"nickname" validate:"min=3"`
+	Address   validateAddress   `json:"address"`
+	Others    []validateAddress `json:"others"`
+	Password  string            `json:"password" validate:"required"`
+	Password2 string            `json:"password2"`
+}
+
+func (s *validateSignup) Validate() error {
+	if s.Password != s.Password2 {
+		return &ValidationError{Fields: []FieldError{{Field: "password2", Rule: "validate", Message: "must match password"}}}
+	}
+
+	return nil
+}
+
+func validSignup() validateSignup {
+	return validateSignup{
+		Name:      "jo",
+		Email:     "jo@example.com",
+		Role:      "admin",
+		Age:       30,
+		Address:   validateAddress{City: "Paris", Zip: "75001"},
+		Password:  "secret",
+		Password2: "secret",
+	}
+}
+
+var validateTests = []struct {
+	name   string
+	change func(s *validateSignup)
+	fields []string
+}{
+	{name: "valid", change: func(s *validateSignup) {}},
+	{name: "missing required", change: func(s *validateSignup) { s.Name, s.Email = "", "" }, fields: []string{"name:required", "email:required"}},
+	{name: "too short", change: func(s *validateSignup) { s.Name = "j" }, fields: []string{"name:min"}},
+	{name: "too long counts runes", change: func(s *validateSignup) { s.Name = "ééééééééééé" }, fields: []string{"name:max"}},
+	{name: "bad email", change: func(s *validateSignup) { s.Email = "Jo <jo@example.com>" }, fields: []string{"email:email"}},
+	{name: "bad url", change: func(s *validateSignup) { s.Site = "example.com" }, fields: []string{"site:url"}},
+	{name: "good url", change: func(s *validateSignup) { s.Site = "https://example.com/jo" }},
+	{name: "not one of", change: func(s *validateSignup) { s.Role = "owner" }, fields: []string{"role:oneof"}},
+	{name: "number range", change: func(s *validateSignup) { s.Age = 12 }, fields: []string{"age:min"}},
+	{name: "too many items", change: func(s *validateSignup) { s.Tags = []string{"a", "b", "c"} }, fields: []string{"tags:max"}},
+	{name: "pointer", change: func(s *validateSignup) { n := "jo"; s.Nickname = &n }, fields: []string{"nickname:min"}},
+	{name: "nested", change: func(s *validateSignup) { s.Address = validateAddress{Zip: "7500A"} }, fields: []string{"address.city:required", "address.zip:regexp"}},
+	{name: "slice of structs", change: func(s *validateSignup) { s.Others = []validateAddress{{City: "Lyon"}, {}} }, fields: []string{"others[1].city:required"}},
+	{name: "validate method", change: func(s *validateSignup) { s.Password2 = "other" }, fields: []string{"password2:validate"}},
+}
+
+func TestTools_Validate(t *testing.T) {
+	var testTools Tools
+
+	for _, e := range validateTests {
+		signup := validSignup()
+		e.change(&signup)
+
+		err := testTools.Validate(&signup)
+
+		var fields []string
+		var validationError *ValidationError
+
+		if errors.As(err, &validationError) {
+			for _, f := range validationError.Fields {
+				fields = append(fields, f.Field+":"+f.Rule)
+			}
+		} else if err != nil {
+			t.Errorf("%s: unexpected error %s", e.name, err)
+		}
+
+		if !reflect.DeepEqual(fields, e.fields) {
+			t.Errorf("%s: expected failing fields %v, got %v", e.name, e.fields, fields)
+		}
+	}
+}
+
+func TestTools_ValidateInvalidTag(t *testing.T) {
+	var testTools Tools
+
+	for _, data := range []any{
+		struct {
+			A string `validate:"min=x"`
+		}{A: "a"},
+		struct {
+			A int `validate:"email"`
+		}{A: 1},
+		struct {
+			A string `validate:"shiny"`
+		}{A: "a"},
+	} {
+		err := testTools.Validate(data)
+
+		if err == nil || errors.Is(err, ErrValidation) {
+			t.Errorf("expected a tag error for %+v, got %v", data, err)
+		}
+	}
+}
+
+func TestTools_ReadJSONValidate(t *testing.T) {
+	testTools := Tools{ValidateJSON: true}
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{"name": "j", "email": "jo@example.com", "role": "admin", "age": 30, "address": {"city": "Paris"}}`))
+	rr := httptest.NewRecorder()
+
+	_, err := ReadJSONAs[validateSignup](rr, req, &testTools)
+
+	if !errors.Is(err, ErrValidation) {
+		t.Fatalf("expected ErrValidation, got %v", err)
+	}
+
+	rr = httptest.NewRecorder()
+
+	if err = testTools.ErrorJSON(rr, err); err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status %d, got %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+
+	var payload Envelope[[]FieldError]
+
+	if err = json.NewDecoder(rr.Body).Decode(&payload); err != nil {
+		t.Fatal(err)
+	}
+
+	if !payload.Error || len(payload.Data) != 2 || payload.Data[0].Field != "name" || payload.Data[1].Field != "password" {
+		t.Errorf("unexpected payload %+v", payload)
+	}
+}